@@ -1,7 +1,9 @@
 package xixo_test
 
 import (
+	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/CGI-FR/xixo/pkg/xixo"
@@ -158,3 +160,529 @@ func mapCallbackAttributsWithParent(dict map[string]string) (map[string]string,
 
 	return dict, nil
 }
+
+const phonesXML = `<root>
+	<name>joe</name>
+	<phone type="home">0102</phone>
+	<phone type="work">0304</phone>
+	<phone type="cell">0506</phone>
+</root>`
+
+func TestMapCallbackWithRepeatedChilds(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(phonesXML)
+
+	edited, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+		assert.Equal(t, "joe", dict["name"])
+		assert.Equal(t, "0102", dict["phone"])
+		assert.Equal(t, "0304", dict["phone[1]"])
+		assert.Equal(t, "cell", dict["phone[2]@type"])
+
+		dict["phone[1]"] = "XXXX"
+		dict["phone[0]@type"] = "masked"
+		delete(dict, "phone[2]")
+
+		return dict, nil
+	})(root)
+	assert.Nil(t, err)
+
+	expected := `<root>
+	<name>joe</name>
+	<phone type="masked">0102</phone>
+	<phone type="work">XXXX</phone>
+</root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestMapCallbackKeyWithoutIndexShouldChangeEveryChild(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(phonesXML)
+
+	edited, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+		dict["phone"] = "XXXX"
+		delete(dict, "phone@type")
+
+		return dict, nil
+	})(root)
+	assert.Nil(t, err)
+
+	expected := `<root>
+	<name>joe</name>
+	<phone>XXXX</phone>
+	<phone>XXXX</phone>
+	<phone>XXXX</phone>
+</root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestJsonCallbackWithRepeatedChilds(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(phonesXML)
+
+	edited, err := xixo.XMLElementToJSONCallback(func(source string) (string, error) {
+		assert.JSONEq(t, `{
+			"name": "joe",
+			"phone": ["0102", "0304", "0506"],
+			"phone@type": ["home", "work", "cell"]
+		}`, source)
+
+		return `{
+			"name": "joe",
+			"phone": ["0102", null, "XXXX"],
+			"phone@type": ["home", "work", "masked"]
+		}`, nil
+	})(root)
+	assert.Nil(t, err)
+
+	expected := `<root>
+	<name>joe</name>
+	<phone type="home">0102</phone>
+	<phone type="work"></phone>
+	<phone type="masked">XXXX</phone>
+</root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestJsonCallbackShouldRejectUnsupportedValues(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(phonesXML)
+
+	_, err := xixo.XMLElementToJSONCallback(func(string) (string, error) {
+		return `{"phone": [{"number": "0102"}]}`, nil
+	})(root)
+
+	assert.ErrorIs(t, err, xixo.ErrUnsupportedJSONValue)
+}
+
+func TestMapCallbackWithDeepPaths(t *testing.T) {
+	t.Parallel()
+
+	rootXML := `<root>
+	<customer id="1">
+		<address kind="home"><city code="44">Nantes</city><street>rue</street></address>
+		<phone>0102</phone>
+		<phone>0304</phone>
+	</customer>
+</root>`
+
+	root := createTreeFromXMLString(rootXML)
+
+	edited, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+		assert.Equal(t, "1", dict["customer@id"])
+		assert.Equal(t, "Nantes", dict["customer/address/city"])
+		assert.Equal(t, "44", dict["customer/address/city@code"])
+		assert.Equal(t, "0304", dict["customer/phone[1]"])
+
+		dict["customer/address/city"] = "Paris"
+		dict["customer/address/city@code"] = "75"
+		dict["customer/phone[1]"] = "XXXX"
+		delete(dict, "customer/address@kind")
+		delete(dict, "customer/address/street")
+
+		return dict, nil
+	})(root)
+	assert.Nil(t, err)
+
+	expected := `<root>
+	<customer id="1">
+		<address><city code="75">Paris</city></address>
+		<phone>0102</phone>
+		<phone>XXXX</phone>
+	</customer>
+</root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestJsonTreeCallbackConventions(t *testing.T) {
+	t.Parallel()
+
+	rootXML := `<root id="1">
+	<name>joe</name>
+	<address kind="home"><city>Nantes</city></address>
+	<phone>0102</phone>
+	<phone>0304</phone>
+	<empty/>
+</root>`
+
+	testCases := []struct {
+		name       string
+		convention xixo.JSONConvention
+		source     string
+		dest       string
+		expected   string
+	}{
+		{
+			name:       "attr text",
+			convention: xixo.AttrTextConvention,
+			source: `{"root":{"@id":"1","name":"joe","address":{"@kind":"home","city":"Nantes"},` +
+				`"phone":["0102","0304"],"empty":""}}`,
+			dest:     `{"root":{"@id":"2","name":{"@lang":"fr","#text":"jo"},"phone":["0102"]}}`,
+			expected: "<root id=\"2\">\n\t<name lang=\"fr\">jo</name>\n\t<phone>0102</phone>\n</root>",
+		},
+		{
+			name:       "badgerfish",
+			convention: xixo.BadgerFishConvention,
+			source: `{"root":{"@id":"1","name":{"$":"joe"},"address":{"@kind":"home","city":{"$":"Nantes"}},` +
+				`"phone":[{"$":"0102"},{"$":"0304"}],"empty":{}}}`,
+			dest:     `{"root":{"@id":"2","name":{"$":"jo"},"empty":{}}}`,
+			expected: "<root id=\"2\">\n\t<name>jo</name>\n\t<empty/>\n</root>",
+		},
+		{
+			name:       "gdata",
+			convention: xixo.GDataConvention,
+			source: `{"root":{"id":"1","name":{"$t":"joe"},"address":{"kind":"home","city":{"$t":"Nantes"}},` +
+				`"phone":[{"$t":"0102"},{"$t":"0304"}],"empty":{}}}`,
+			dest:     `{"root":{"id":"2","address":{"kind":"work","city":{"$t":"Paris"}}}}`,
+			expected: "<root id=\"2\">\n\t<address kind=\"work\"><city>Paris</city></address>\n</root>",
+		},
+		{
+			name:       "parker",
+			convention: xixo.ParkerConvention,
+			source:     `{"name":"joe","address":{"city":"Nantes"},"phone":["0102","0304"],"empty":null}`,
+			dest:       `{"name":"jo","phone":["0102","0506"],"empty":null}`,
+			expected: "<root id=\"1\">\n\t<name>jo</name>\n\t<phone>0102</phone>\n\t<phone>0506</phone>\n" +
+				"\t<empty/>\n</root>",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			root := createTreeFromXMLString(rootXML)
+
+			edited, err := xixo.XMLElementToJSONTreeCallback(tc.convention, func(source string) (string, error) {
+				assert.Equal(t, tc.source, source)
+
+				return tc.dest, nil
+			})(root)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, edited.String())
+
+			// unchanged JSON keeps the element as read
+			unchanged, err := xixo.XMLElementToJSONTreeCallback(tc.convention, func(source string) (string, error) {
+				return source, nil
+			})(createTreeFromXMLString(rootXML))
+			assert.Nil(t, err)
+			assert.Equal(t, rootXML, unchanged.String())
+		})
+	}
+}
+
+func TestJsonTreeCallbackShouldRejectUnsupportedJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, dest := range []string{
+		`{"a":{},"b":{}}`, `{"root":{"@id":{}}}`, `{"root":[]}`, `{"root":{}} {}`,
+		`{"root":{"bad key":"x"}}`, `{"root":{"@":"y"}}`, `{"root":{"<x":"1"}}`, `{"1root":{}}`,
+	} {
+		_, err := xixo.XMLElementToJSONTreeCallback(xixo.AttrTextConvention, func(string) (string, error) {
+			return dest, nil
+		})(createTreeFromXMLString(rootXML))
+
+		assert.ErrorIs(t, err, xixo.ErrUnsupportedJSONValue, dest)
+	}
+}
+
+func TestJsonTreeCallbackShouldDescribeUnsupportedValues(t *testing.T) {
+	t.Parallel()
+
+	_, err := xixo.XMLElementToJSONTreeCallback(xixo.AttrTextConvention, func(string) (string, error) {
+		return `{"root":{"@id":{"x":1}}}`, nil
+	})(createTreeFromXMLString(rootXML))
+
+	assert.ErrorIs(t, err, xixo.ErrUnsupportedJSONValue)
+	assert.EqualError(t, err, "unsupported JSON value: object for attribute id")
+}
+
+func TestJsonTreeCallbackShouldMergeOntoElement(t *testing.T) {
+	t.Parallel()
+
+	rootXML := `<root xmlns:p="urn:p" id="1">
+	<!-- people -->
+	before<p:m>joe</p:m>
+	<p:m>ann</p:m>
+</root>`
+
+	testCases := []struct {
+		name       string
+		convention xixo.JSONConvention
+		dest       func(string) string
+		expected   string
+	}{
+		{
+			name:       "attr text",
+			convention: xixo.AttrTextConvention,
+			dest: func(source string) string {
+				return strings.Replace(source, "before", "after", 1)
+			},
+			expected: `<root xmlns:p="urn:p" id="1">
+	<!-- people -->
+	after<p:m>joe</p:m>
+	<p:m>ann</p:m>
+</root>`,
+		},
+		{
+			name:       "parker",
+			convention: xixo.ParkerConvention,
+			dest: func(string) string {
+				return `{"p:m":["jo","an","al"]}`
+			},
+			expected: `<root xmlns:p="urn:p" id="1">
+	<!-- people -->
+	before<p:m>jo</p:m>
+	<p:m>an</p:m>
+	<p:m>al</p:m>
+</root>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			edited, err := xixo.XMLElementToJSONTreeCallback(tc.convention, func(source string) (string, error) {
+				return tc.dest(source), nil
+			})(createTreeFromXMLString(rootXML))
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, edited.String())
+		})
+	}
+}
+
+func TestTypedJSONCallback(t *testing.T) {
+	t.Parallel()
+
+	rootXML := `<root xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" id="7">
+	<age>42</age>
+	<amount>12345678901234567890.123456789</amount>
+	<active>true</active>
+	<phone>0102</phone>
+	<phone xsi:nil="true"/>
+	<zip/>
+</root>`
+
+	options := xixo.JSONOptions{
+		Types: map[string]xixo.JSONType{
+			"@id":    xixo.JSONNumber,
+			"age":    xixo.JSONNumber,
+			"amount": xixo.JSONNumber,
+			"active": xixo.JSONBoolean,
+			"phone":  xixo.JSONNumber,
+			"zip":    xixo.JSONNumber,
+		},
+		XSINil: true,
+	}
+
+	callback := xixo.XMLElementToTypedJSONCallbackContext(options, func(_ context.Context, source string) (string, error) {
+		assert.JSONEq(t, `{
+			"@xmlns:xsi": "http://www.w3.org/2001/XMLSchema-instance",
+			"@id": 7,
+			"age": 42,
+			"amount": 12345678901234567890.123456789,
+			"active": true,
+			"phone": ["0102", null],
+			"phone@xsi:nil": [null, "true"],
+			"zip": null
+		}`, source)
+
+		return `{
+			"@xmlns:xsi": "http://www.w3.org/2001/XMLSchema-instance",
+			"@id": 8,
+			"age": null,
+			"amount": 98765432109876543210.987654321,
+			"active": false,
+			"phone": [null, 304],
+			"phone@xsi:nil": [null, "true"],
+			"zip": 44000
+		}`, nil
+	})
+
+	edited, err := callback(context.Background(), createTreeFromXMLString(rootXML))
+	assert.Nil(t, err)
+
+	expected := `<root xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" id="8">
+	<age xsi:nil="true"></age>
+	<amount>98765432109876543210.987654321</amount>
+	<active>false</active>
+	<phone xsi:nil="true"></phone>
+	<phone>304</phone>
+	<zip>44000</zip>
+</root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestTypedJSONTreeCallback(t *testing.T) {
+	t.Parallel()
+
+	rootXML := `<root><age>42</age><name>joe</name><nick/></root>`
+
+	options := xixo.JSONOptions{
+		Convention: xixo.AttrTextConvention,
+		Types:      map[string]xixo.JSONType{"age": xixo.JSONNumber},
+		XSINil:     true,
+	}
+
+	callback := xixo.XMLElementToTypedJSONTreeCallbackContext(options, func(_ context.Context, source string) (string, error) {
+		assert.Equal(t, `{"root":{"age":42,"name":"joe","nick":""}}`, source)
+
+		return `{"root":{"age":43.50,"name":null,"nick":true}}`, nil
+	})
+
+	edited, err := callback(context.Background(), createTreeFromXMLString(rootXML))
+	assert.Nil(t, err)
+
+	expected := `<root xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<age>43.50</age><name xsi:nil="true"></name><nick>true</nick></root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestMapCallbackShouldCreateChilds(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(phonesXML)
+
+	edited, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+		dict["email"] = "joe@example.com"
+		dict["email@type"] = "work"
+		dict["phone[3]"] = "0708"
+		dict["address/city"] = "Nantes"
+		dict["address/city@code"] = "44"
+
+		return dict, nil
+	})(root)
+	assert.Nil(t, err)
+
+	expected := `<root>
+	<name>joe</name>
+	<phone type="home">0102</phone>
+	<phone type="work">0304</phone>
+	<phone type="cell">0506</phone>
+	<phone>0708</phone>
+	<address><city code="44">Nantes</city></address>
+	<email type="work">joe@example.com</email>
+</root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestMapCallbackShouldRejectInvalidKeys(t *testing.T) {
+	t.Parallel()
+
+	for _, key := range []string{"x@", "@", "name@a b", "not a name", "address/<city", "phone[1]@1st"} {
+		root := createTreeFromXMLString(phonesXML)
+
+		_, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+			dict[key] = "y"
+
+			return dict, nil
+		})(root)
+
+		assert.ErrorIs(t, err, xixo.ErrInvalidMapKey, key)
+		assert.Equal(t, phonesXML, root.String(), key)
+	}
+}
+
+func TestMapCallbackShouldCreateChildsInEmptyElement(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(`<root><customer/></root>`)
+
+	edited, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+		dict["customer/name"] = "joe"
+		dict["customer/phone[0]"] = "0102"
+
+		return dict, nil
+	})(root)
+	assert.Nil(t, err)
+
+	assert.Equal(t, `<root><customer><name>joe</name><phone>0102</phone></customer></root>`, edited.String())
+}
+
+func TestMapCallbackShouldAddAttributesInSortedOrder(t *testing.T) {
+	t.Parallel()
+
+	for range 20 {
+		root := createTreeFromXMLString(`<root b="1"><name z="1">joe</name></root>`)
+
+		edited, err := xixo.XMLElementToMapCallback(func(dict map[string]string) (map[string]string, error) {
+			dict["@d"] = "4"
+			dict["@a"] = "2"
+			dict["@c"] = "3"
+			dict["name@y"] = "2"
+			dict["name@x"] = "3"
+
+			return dict, nil
+		})(root)
+		assert.Nil(t, err)
+
+		assert.Equal(t, `<root b="1" a="2" c="3" d="4"><name z="1" x="3" y="2">joe</name></root>`, edited.String())
+	}
+}
+
+func TestOrderedMapCallback(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(`<root b="1"><name z="1">joe</name><phone>1</phone><phone>2</phone></root>`)
+
+	edited, err := xixo.XMLElementToOrderedMapCallback(func(dict *xixo.OrderedMap) (*xixo.OrderedMap, error) {
+		assert.Equal(t, []string{
+			"@b", "name", "name@z", "phone[0]", "phone", "phone[1]",
+		}, dict.Keys())
+
+		dict.Set("@d", "4")
+		dict.Set("@a", "2")
+		dict.Set("name@y", "2")
+		dict.Set("name@x", "3")
+		dict.Set("email", "joe@example.com")
+		dict.Set("address", "Nantes")
+		dict.Delete("phone[1]")
+
+		return dict, nil
+	})(root)
+	assert.Nil(t, err)
+
+	expected := `<root b="1" d="4" a="2"><name z="1" y="2" x="3">joe</name><phone>1</phone>` +
+		`<email>joe@example.com</email><address>Nantes</address></root>`
+
+	assert.Equal(t, expected, edited.String())
+}
+
+func TestJsonCallbackShouldFollowDocumentOrder(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(`<root b="1"><zeta>z</zeta><alpha>a</alpha></root>`)
+
+	_, err := xixo.XMLElementToJSONCallback(func(source string) (string, error) {
+		assert.Equal(t, `{"@b":"1","zeta":"z","alpha":"a"}`, source)
+
+		return source, nil
+	})(root)
+	assert.Nil(t, err)
+}
+
+func TestJsonCallbackShouldRoundTripNestedRepeatedChilds(t *testing.T) {
+	t.Parallel()
+
+	input := `<root><c><d>1</d></c><c><d>2</d><d>3</d></c></root>`
+	root := createTreeFromXMLString(input)
+
+	edited, err := xixo.XMLElementToJSONCallback(func(source string) (string, error) {
+		assert.JSONEq(t, `{"c":["",""],"c[0]/d":"1","c[1]/d":["2","3"]}`, source)
+
+		return source, nil
+	})(root)
+	assert.Nil(t, err)
+
+	assert.Equal(t, input, edited.String())
+}
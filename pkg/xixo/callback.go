@@ -1,45 +1,165 @@
 package xixo
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+// Callback returns the element written in place of a matched element. Returning a nil element removes it,
+// as ErrDropElement does.
 type Callback func(*XMLElement) (*XMLElement, error)
 
 type CallbackMap func(map[string]string) (map[string]string, error)
 
 type CallbackJSON func(string) (string, error)
 
+// CallbackContext is a Callback receiving the context of the stream, to honor its cancellation.
+type CallbackContext func(context.Context, *XMLElement) (*XMLElement, error)
+
+// CallbackMapContext is a CallbackMap receiving the context of the stream.
+type CallbackMapContext func(context.Context, map[string]string) (map[string]string, error)
+
+// CallbackJSONContext is a CallbackJSON receiving the context of the stream.
+type CallbackJSONContext func(context.Context, string) (string, error)
+
+// CallbackOrderedMap is a CallbackMap receiving the keys in document order, and returning keys
+// whose order decides the order of the attributes and children it creates.
+type CallbackOrderedMap func(*OrderedMap) (*OrderedMap, error)
+
+// CallbackOrderedMapContext is a CallbackOrderedMap receiving the context of the stream.
+type CallbackOrderedMapContext func(context.Context, *OrderedMap) (*OrderedMap, error)
+
+// CallbackNodes returns the nodes written in place of a matched element: none to remove it, several
+// elements to split it, or a comment to replace it. Each node is written with the indentation of the element.
+type CallbackNodes func(*XMLElement) ([]*Node, error)
+
+// CallbackNodesContext is a CallbackNodes receiving the context of the stream.
+type CallbackNodesContext func(context.Context, *XMLElement) ([]*Node, error)
+
+// IgnoreContext adapts a callback to the context-aware form, a nil callback staying nil.
+func IgnoreContext(callback Callback) CallbackContext {
+	if callback == nil {
+		return nil
+	}
+
+	return func(_ context.Context, xmlElement *XMLElement) (*XMLElement, error) {
+		return callback(xmlElement)
+	}
+}
+
+// IgnoreNodesContext adapts a nodes callback to the context-aware form.
+func IgnoreNodesContext(callback CallbackNodes) CallbackNodesContext {
+	return func(_ context.Context, xmlElement *XMLElement) ([]*Node, error) {
+		return callback(xmlElement)
+	}
+}
+
+// elementNodes adapts a callback returning an element to the nodes form, ErrDropElement and a nil element
+// standing for no node. A nil callback stays nil.
+func elementNodes(callback CallbackContext) CallbackNodesContext {
+	if callback == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, xmlElement *XMLElement) ([]*Node, error) {
+		mutatedElement, err := callback(ctx, xmlElement)
+		if errors.Is(err, ErrDropElement) {
+			return nil, nil
+		}
+
+		if err != nil || mutatedElement == nil {
+			return nil, err
+		}
+
+		return []*Node{{Kind: ElementNode, Element: mutatedElement}}, nil
+	}
+}
+
 // XMLElementToMapCallback transforms an XML element into a map, applies a callback function,
 // adds parent attributes, and updates child elements.
 func XMLElementToMapCallback(callback CallbackMap) Callback {
-	result := func(xmlElement *XMLElement) (*XMLElement, error) {
-		dict := map[string]string{}
-		for name, child := range xmlElement.Childs {
-			dict[name] = child[0].InnerText
+	result := XMLElementToMapCallbackContext(func(_ context.Context, dict map[string]string) (map[string]string, error) {
+		return callback(dict)
+	})
+
+	return func(xmlElement *XMLElement) (*XMLElement, error) {
+		return result(context.Background(), xmlElement)
+	}
+}
+
+// XMLElementToMapCallbackContext is XMLElementToMapCallback for a context-aware callback.
+// Attributes and children created by the callback are added in the order of their keys.
+func XMLElementToMapCallbackContext(callback CallbackMapContext) CallbackContext {
+	return XMLElementToOrderedMapCallbackContext(func(ctx context.Context, dict *OrderedMap) (*OrderedMap, error) {
+		result, err := callback(ctx, dict.Map())
+		if err != nil {
+			return nil, err
 		}
 
-		extractExistedAttributes(xmlElement, dict)
+		return orderedResult(dict, result), nil
+	})
+}
+
+// XMLElementToOrderedMapCallback is XMLElementToMapCallback for a callback receiving an ordered map.
+func XMLElementToOrderedMapCallback(callback CallbackOrderedMap) Callback {
+	result := XMLElementToOrderedMapCallbackContext(func(_ context.Context, dict *OrderedMap) (*OrderedMap, error) {
+		return callback(dict)
+	})
 
-		dict, err := callback(dict)
+	return func(xmlElement *XMLElement) (*XMLElement, error) {
+		return result(context.Background(), xmlElement)
+	}
+}
+
+// XMLElementToOrderedMapCallbackContext is XMLElementToOrderedMapCallback for a context-aware callback.
+// Descendants are keyed by their path like address/city, a step being indexed like phone[1] when
+// siblings share its name, and their attributes like address/city@code. For direct children the
+// key without index stands for the first one when read and for all of them when changed or deleted.
+// Keys matching no descendant create it, after its siblings of the same name. The keys are given
+// in document order, the attributes of an element following it.
+func XMLElementToOrderedMapCallbackContext(callback CallbackOrderedMapContext) CallbackContext {
+	result := func(ctx context.Context, xmlElement *XMLElement) (*XMLElement, error) {
+		entries := mapEntries(xmlElement.Children(), "", true)
+		original := elementToMap(xmlElement, entries)
+
+		dict, err := callback(ctx, original.clone())
 		if err != nil {
 			return nil, err
 		}
 
-		if len(xmlElement.Childs) > 0 {
-			existingChilds := make(map[string]bool)
-			for key := range dict {
-				existingChilds[key] = true
-			}
-			// Check if the attribute is already present
-			for name := range xmlElement.Childs {
-				if !existingChilds[name] {
-					xmlElement.RemoveChild(name)
+		changes := newMapChanges(original, dict)
+
+		err = changes.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		// Remove the descendants whose key was deleted, by name or by index, with their subtree.
+		removed := map[*XMLElement]bool{}
+		parents := []*XMLElement{}
+
+		for _, entry := range entries {
+			switch {
+			case removed[entry.element.parent]:
+				removed[entry.element] = true
+			case changes.deleted(entry.key) || (entry.alias != "" && changes.deleted(entry.alias)):
+				removed[entry.element] = true
+
+				if !slices.Contains(parents, entry.element.parent) {
+					parents = append(parents, entry.element.parent)
 				}
 			}
 		}
 
+		for _, parent := range parents {
+			parent.removeChildren(func(child *XMLElement) bool { return removed[child] })
+		}
+
 		// Extract parent attributes and add them to the XML element.
 		parentAttributes := extractParentAttributes(dict)
 		for _, attr := range parentAttributes {
@@ -47,87 +167,314 @@ func XMLElementToMapCallback(callback CallbackMap) Callback {
 		}
 		// Apply remove on parentAttributes
 		removeAttributes(parentAttributes, xmlElement)
-		children := xmlElement.childs
 
-		// Select child elements and update their text content and attributes.
-		childAttributes := extractChildAttributes(dict)
-
-		for _, child := range children {
-			if value, ok := dict[child.Name]; ok {
-				child.InnerText = value
+		// Update the text content and attributes of the descendants, changes by index coming last.
+		for _, entry := range entries {
+			if removed[entry.element] {
+				continue
 			}
 
-			attributes, ok := childAttributes[child.Name]
-			if ok {
-				// Add new attributes
-				for _, attr := range attributes {
-					child.AddAttribute(attr)
-				}
+			if entry.alias != "" {
+				changes.apply(entry.element, entry.alias)
 			}
 
-			// Apply remove on child attributes
-			removeAttributes(attributes, child)
+			changes.apply(entry.element, entry.key)
 		}
 
+		changes.create(xmlElement, entries, removed)
+
 		return xmlElement, nil
 	}
 
 	return result
 }
 
-func removeAttributes(attributes []Attribute, element *XMLElement) {
-	// Check if attributes are available for the current child
-	existingAttributes := make(map[string]bool)
-	for _, existAttribute := range attributes {
-		existingAttributes[existAttribute.Name] = true
+// create adds the elements of the keys matching no descendant, in the order of the keys, after their
+// siblings of the same name. Missing ancestors are created too, unless they were removed.
+func (changes mapChanges) create(xmlElement *XMLElement, entries []mapEntry, removed map[*XMLElement]bool) {
+	known := map[string]*XMLElement{}
+
+	for _, entry := range entries {
+		known[entry.key] = entry.element
+
+		if entry.alias != "" {
+			known[entry.alias] = entry.element
+		}
 	}
-	// Check if the attribute is already present
-	for _, xmlAttributeName := range element.AttrKeys {
-		if !existingAttributes[xmlAttributeName] {
-			element.RemoveAttribute(xmlAttributeName)
+
+	for _, key := range changes.keys {
+		elementKey, _, _ := strings.Cut(key, "@")
+		if _, ok := known[elementKey]; ok || elementKey == "" {
+			continue
+		}
+
+		element := changes.resolve(xmlElement, known, removed, elementKey)
+		if element != nil {
+			changes.apply(element, elementKey)
 		}
 	}
 }
 
-func extractExistedAttributes(xmlElement *XMLElement, dict map[string]string) {
-	for name, child := range xmlElement.Childs {
-		for attrName, attr := range child[0].Attrs {
-			dict[name+"@"+attrName] = attr.Value
+// resolve returns the descendant at key, creating it when missing. A step indexed like phone[1]
+// is the child of this index when it exists. It returns nil under a removed element.
+func (changes mapChanges) resolve(
+	xmlElement *XMLElement, known map[string]*XMLElement, removed map[*XMLElement]bool, key string,
+) *XMLElement {
+	if element, ok := known[key]; ok {
+		if removed[element] {
+			return nil
+		}
+
+		return element
+	}
+
+	parent := xmlElement
+
+	parentKey, step, nested := cutLast(key, "/")
+	if nested {
+		parent = changes.resolve(xmlElement, known, removed, parentKey)
+		if parent == nil {
+			return nil
 		}
 	}
 
-	for attrName, attr := range xmlElement.Attrs {
-		dict["@"+attrName] = attr.Value
+	name, index, indexed := splitIndexedKey(step)
+	if !isXMLName(name) {
+		return nil
 	}
+
+	if siblings := parent.ChildrenNamed(name); indexed && index < len(siblings) {
+		known[key] = siblings[index]
+
+		return siblings[index]
+	}
+
+	element := NewXMLElement()
+	element.Name = name
+
+	parent.insertChild(element)
+	known[key] = element
+
+	return element
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s string, sep string) (string, string, bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return "", s, false
+}
+
+// mapEntry is a descendant of the element given to a map callback, with its key.
+type mapEntry struct {
+	element *XMLElement
+	key     string
+	// alias is the key without index of a direct child sharing its name with siblings
+	alias string
+	first bool
+}
+
+// mapEntries returns the entries of children and their descendants in document order.
+func mapEntries(children []*XMLElement, prefix string, direct bool) []mapEntry {
+	entries := []mapEntry{}
+
+	for i, key := range childKeys(children) {
+		entry := mapEntry{element: children[i], key: prefix + key}
+
+		if direct && key != children[i].Name {
+			entry.alias = children[i].Name
+			entry.first = key == indexedKey(children[i].Name, 0)
+		}
+
+		entries = append(entries, entry)
+		entries = append(entries, mapEntries(children[i].Children(), entry.key+"/", false)...)
+	}
+
+	return entries
+}
+
+// elementToMap returns the texts of the descendants and the attributes of the element and its descendants.
+func elementToMap(xmlElement *XMLElement, entries []mapEntry) *OrderedMap {
+	dict := NewOrderedMap()
+
+	for _, attrName := range xmlElement.AttrKeys {
+		dict.Set("@"+attrName, xmlElement.Attrs[attrName].Value)
+	}
+
+	for _, entry := range entries {
+		keys := []string{entry.key}
+		if entry.first {
+			keys = append(keys, entry.alias)
+		}
+
+		for _, key := range keys {
+			dict.Set(key, entry.element.InnerText)
+
+			for _, attrName := range entry.element.AttrKeys {
+				dict.Set(key+"@"+attrName, entry.element.Attrs[attrName].Value)
+			}
+		}
+	}
+
+	return dict
+}
+
+// childKeys returns the key of each child, indexed when its name is shared with other children.
+func childKeys(children []*XMLElement) []string {
+	counts := map[string]int{}
+
+	for _, child := range children {
+		counts[child.Name]++
+	}
+
+	keys := make([]string, len(children))
+	indexes := map[string]int{}
+
+	for i, child := range children {
+		if counts[child.Name] == 1 {
+			keys[i] = child.Name
+
+			continue
+		}
+
+		keys[i] = indexedKey(child.Name, indexes[child.Name])
+		indexes[child.Name]++
+	}
+
+	return keys
+}
+
+// indexedKey returns the key of the child of the given index among the children named name.
+func indexedKey(name string, index int) string {
+	return name + "[" + strconv.Itoa(index) + "]"
+}
+
+// splitIndexedKey splits a key like phone[1]@type into phone@type and 1. Only the index of the last
+// step is split, those of its ancestors staying in the key: c[0]/d[1] becomes c[0]/d and 1.
+func splitIndexedKey(key string) (string, int, bool) {
+	step := strings.LastIndexByte(key, '/') + 1
+	name, _, _ := strings.Cut(key[step:], "@")
+
+	open := strings.IndexByte(name, '[')
+	if open <= 0 || !strings.HasSuffix(name, "]") {
+		return key, 0, false
+	}
+
+	index, err := strconv.Atoi(name[open+1 : len(name)-1])
+	if err != nil || index < 0 {
+		return key, 0, false
+	}
+
+	return key[:step+open] + key[step+len(name):], index, true
+}
+
+// mapChanges compares the map returned by a callback to the one it received.
+type mapChanges struct {
+	original map[string]string
+	dict     map[string]string
+	keys     []string
+	// attrs lists the attribute names present in dict for each element key, in order
+	attrs map[string][]string
+}
+
+func newMapChanges(original *OrderedMap, dict *OrderedMap) mapChanges {
+	attrs := map[string][]string{}
+
+	for _, key := range dict.keys {
+		if elementKey, attrName, ok := strings.Cut(key, "@"); ok && elementKey != "" {
+			attrs[elementKey] = append(attrs[elementKey], attrName)
+		}
+	}
+
+	return mapChanges{original: original.values, dict: dict.values, keys: dict.keys, attrs: attrs}
 }
 
-// extractChildAttributes extracts child attributes from the dictionary.
-func extractChildAttributes(dict map[string]string) map[string][]Attribute {
-	childAttributes := make(map[string][]Attribute)
-	// check dict[name] include "@"
-	for key, value := range dict {
-		parts := strings.SplitN(key, "@", 2)
+// validate checks that the keys added by the callback are made of element and attribute names.
+func (changes mapChanges) validate() error {
+	for _, key := range changes.keys {
+		if _, ok := changes.original[key]; ok {
+			continue
+		}
+
+		elementKey, attrName, isAttr := strings.Cut(key, "@")
+		if isAttr && !isXMLName(attrName) {
+			return fmt.Errorf("%w: %q", ErrInvalidMapKey, key)
+		}
+
+		if elementKey == "" && isAttr {
+			continue
+		}
 
-		if len(parts) == 2 {
-			tagName := parts[0]
-			newAttribut := Attribute{Name: parts[1], Value: value}
-			// if key already in attributes
-			if existingElement, ok := childAttributes[tagName]; ok {
-				childAttributes[tagName] = append(existingElement, newAttribut)
-			} else {
-				childAttributes[tagName] = []Attribute{newAttribut}
+		for _, step := range strings.Split(elementKey, "/") {
+			if name, _, _ := splitIndexedKey(step); !isXMLName(name) {
+				return fmt.Errorf("%w: %q", ErrInvalidMapKey, key)
 			}
 		}
 	}
 
-	return childAttributes
+	return nil
+}
+
+// deleted reports whether key was removed by the callback.
+func (changes mapChanges) deleted(key string) bool {
+	_, was := changes.original[key]
+	_, is := changes.dict[key]
+
+	return was && !is
+}
+
+// changed returns the value of key when the callback added or modified it.
+func (changes mapChanges) changed(key string) (string, bool) {
+	value, ok := changes.dict[key]
+	if !ok {
+		return "", false
+	}
+
+	old, was := changes.original[key]
+
+	return value, !was || old != value
+}
+
+// apply updates the text and attributes of element from the changes of key and key@attribute entries.
+func (changes mapChanges) apply(element *XMLElement, key string) {
+	if value, ok := changes.changed(key); ok {
+		element.InnerText = value
+	}
+
+	for _, attrName := range slices.Clone(element.AttrKeys) {
+		if changes.deleted(key + "@" + attrName) {
+			element.RemoveAttribute(attrName)
+		}
+	}
+
+	for _, attrName := range changes.attrs[key] {
+		if value, ok := changes.changed(key + "@" + attrName); ok {
+			element.AddAttribute(Attribute{Name: attrName, Value: value})
+		}
+	}
+}
+
+func removeAttributes(attributes []Attribute, element *XMLElement) {
+	// Check if attributes are available for the current child
+	existingAttributes := make(map[string]bool)
+	for _, existAttribute := range attributes {
+		existingAttributes[existAttribute.Name] = true
+	}
+	// Check if the attribute is already present
+	for _, xmlAttributeName := range element.AttrKeys {
+		if !existingAttributes[xmlAttributeName] {
+			element.RemoveAttribute(xmlAttributeName)
+		}
+	}
 }
 
 // extractParentAttributes extracts parent attributes from the dictionary.
-func extractParentAttributes(dict map[string]string) []Attribute {
+func extractParentAttributes(dict *OrderedMap) []Attribute {
 	parentAttributes := []Attribute{}
 
-	for key, value := range dict {
+	for key, value := range dict.All() {
 		if strings.HasPrefix(key, "@") {
 			attributeKey := key[1:]
 			attribute := Attribute{Name: attributeKey, Value: value}
@@ -139,26 +486,241 @@ func extractParentAttributes(dict map[string]string) []Attribute {
 }
 
 func XMLElementToJSONCallback(callback CallbackJSON) Callback {
-	resultCallback := func(dict map[string]string) (map[string]string, error) {
-		source, err := json.Marshal(dict)
+	result := XMLElementToJSONCallbackContext(func(_ context.Context, source string) (string, error) {
+		return callback(source)
+	})
+
+	return func(xmlElement *XMLElement) (*XMLElement, error) {
+		return result(context.Background(), xmlElement)
+	}
+}
+
+// XMLElementToJSONCallbackContext is XMLElementToJSONCallback for a context-aware callback.
+// Children sharing a name are an array of texts, their attributes an array of values under the
+// name@attribute key. Their descendants keep the index of their ancestor, as in c[0]/d.
+func XMLElementToJSONCallbackContext(callback CallbackJSONContext) CallbackContext {
+	return XMLElementToTypedJSONCallbackContext(JSONOptions{}, callback)
+}
+
+// XMLElementToTypedJSONCallbackContext is XMLElementToJSONCallbackContext with values typed by options.
+func XMLElementToTypedJSONCallbackContext(options JSONOptions, callback CallbackJSONContext) CallbackContext {
+	resultCallback := func(ctx context.Context, dict *OrderedMap) (*OrderedMap, error) {
+		source, err := json.Marshal(options.groupIndexedKeys(dict))
+		if err != nil {
+			return nil, err
+		}
+
+		dest, err := callback(ctx, string(source))
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := decodeJSON(dest)
+		if err != nil {
+			return nil, err
+		}
+
+		object, ok := value.(jsonObject)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected an object", ErrUnsupportedJSONValue)
+		}
+
+		return options.expandIndexedKeys(object, dict)
+	}
+
+	return XMLElementToOrderedMapCallbackContext(resultCallback)
+}
+
+// XMLElementToJSONTreeCallback converts the whole element tree to JSON in the given convention, applies
+// the callback and merges the returned JSON onto the element: changed texts and attributes are set in place,
+// missing children are created and those left out removed, the indentation and comments being kept.
+func XMLElementToJSONTreeCallback(convention JSONConvention, callback CallbackJSON) Callback {
+	result := XMLElementToJSONTreeCallbackContext(convention, func(_ context.Context, source string) (string, error) {
+		return callback(source)
+	})
+
+	return func(xmlElement *XMLElement) (*XMLElement, error) {
+		return result(context.Background(), xmlElement)
+	}
+}
+
+// XMLElementToJSONTreeCallbackContext is XMLElementToJSONTreeCallback for a context-aware callback.
+func XMLElementToJSONTreeCallbackContext(convention JSONConvention, callback CallbackJSONContext) CallbackContext {
+	return XMLElementToTypedJSONTreeCallbackContext(JSONOptions{Convention: convention}, callback)
+}
+
+// XMLElementToTypedJSONTreeCallbackContext is XMLElementToJSONTreeCallbackContext with values typed by options.
+func XMLElementToTypedJSONTreeCallbackContext(options JSONOptions, callback CallbackJSONContext) CallbackContext {
+	return func(ctx context.Context, xmlElement *XMLElement) (*XMLElement, error) {
+		source, err := json.Marshal(options.elementToJSON(xmlElement))
 		if err != nil {
 			return nil, err
 		}
 
-		dest, err := callback(string(source))
+		dest, err := callback(ctx, string(source))
 		if err != nil {
 			return nil, err
 		}
 
-		result := map[string]string{}
+		if dest == string(source) {
+			return xmlElement, nil
+		}
 
-		err = json.Unmarshal([]byte(dest), &result)
+		value, err := decodeJSON(dest)
 		if err != nil {
 			return nil, err
 		}
 
-		return result, nil
+		err = options.mergeIntoElement(xmlElement, value)
+		if err != nil {
+			return nil, err
+		}
+
+		return xmlElement, nil
+	}
+}
+
+// groupIndexedKeys gathers the values of indexed keys in arrays, in place of the key without index.
+// Members follow the order of the keys.
+func (options JSONOptions) groupIndexedKeys(dict *OrderedMap) jsonObject {
+	object := jsonObject{}
+	positions := map[string]int{}
+
+	for key, value := range dict.All() {
+		typed := options.typed(key, value, options.isNil(dict, key))
+
+		base, index, indexed := splitIndexedKey(key)
+		if !indexed {
+			if _, ok := positions[key]; !ok {
+				positions[key] = len(object)
+				object = append(object, jsonMember{key: key, value: typed})
+			}
+
+			continue
+		}
+
+		position, ok := positions[base]
+		if !ok {
+			position = len(object)
+			positions[base] = position
+			object = append(object, jsonMember{key: base})
+		}
+
+		array, _ := object[position].value.([]any)
+		for len(array) <= index {
+			array = append(array, nil)
+		}
+
+		array[index] = typed
+		object[position].value = array
+	}
+
+	return object
+}
+
+// isNil reports whether the element of key is nil, having the attribute xsi:nil="true".
+func (options JSONOptions) isNil(dict *OrderedMap, key string) bool {
+	if !options.XSINil || strings.Contains(key, "@") {
+		return false
+	}
+
+	value, _ := dict.Get(key + "@" + xsiNil)
+
+	return value == "true"
+}
+
+// expandIndexedKeys spreads the arrays of values over indexed keys. A key that was an array in the
+// source keeps the entries of the original map it does not change.
+func (options JSONOptions) expandIndexedKeys(object jsonObject, original *OrderedMap) (*OrderedMap, error) {
+	grouped := map[string][]string{}
+
+	for _, key := range original.keys {
+		if base, _, ok := splitIndexedKey(key); ok {
+			grouped[base] = append(grouped[base], key)
+		}
+	}
+
+	dict := NewOrderedMap()
+	notNil := []string{}
+
+	for _, member := range object {
+		key := member.key
+
+		array, ok := member.value.([]any)
+		if !ok {
+			// a single value changes every child of the array
+			for _, indexed := range grouped[key] {
+				dict.Set(indexed, original.values[indexed])
+			}
+
+			null, err := options.setValue(dict, original, key, member.value)
+			if err != nil {
+				return nil, err
+			}
+
+			if !null {
+				notNil = append(notNil, key)
+			}
+
+			continue
+		}
+
+		name, attr, isAttr := strings.Cut(key, "@")
+		if name == "" {
+			return nil, fmt.Errorf("%w: array for %s", ErrUnsupportedJSONValue, key)
+		}
+
+		for index, item := range array {
+			indexed := indexedKey(name, index)
+			if isAttr {
+				indexed += "@" + attr
+			}
+
+			null, err := options.setValue(dict, original, indexed, item)
+			if err != nil {
+				return nil, err
+			}
+
+			if !null {
+				notNil = append(notNil, indexed)
+			}
+		}
+
+		if text, ok := original.Get(key); ok && len(grouped[key]) > 0 {
+			dict.Set(key, text)
+		}
+	}
+
+	for _, key := range notNil {
+		if options.XSINil && !strings.Contains(key, "@") {
+			dict.Delete(key + "@" + xsiNil)
+		}
+	}
+
+	return dict, nil
+}
+
+// setValue sets the text of key from a JSON value, null leaving an attribute out and making
+// an element empty or nil. It returns whether the value is null.
+func (options JSONOptions) setValue(dict *OrderedMap, original *OrderedMap, key string, value any) (bool, error) {
+	text, null, ok := scalarText(value)
+
+	switch {
+	case !ok:
+		return false, fmt.Errorf("%w: %s for %s", ErrUnsupportedJSONValue, jsonKind(value), key)
+	case !null:
+		dict.Set(key, text)
+	case !strings.Contains(key, "@"):
+		dict.Set(key, "")
+
+		if options.XSINil {
+			dict.Set(key+"@"+xsiNil, "true")
+
+			if _, ok := original.Get(key + "@xmlns:xsi"); !ok && original.values["@xmlns:xsi"] == "" {
+				dict.Set(key+"@xmlns:xsi", XSINamespaceURI)
+			}
+		}
 	}
 
-	return XMLElementToMapCallback(resultCallback)
+	return null, nil
 }
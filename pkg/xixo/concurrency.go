@@ -0,0 +1,242 @@
+package xixo
+
+import (
+	"bufio"
+	"context"
+	"sync"
+)
+
+// maxBufferedOutput bounds the bytes held after pending elements before the parser waits for them.
+const maxBufferedOutput = 1 << 20
+
+// rendering is the output of a callback run by a worker.
+type rendering struct {
+	// elements are returned by Next
+	elements []*XMLElement
+	// data is written in place of the deferred bytes of the element, or when replace is set
+	// nodes are written in place of the element, each with its indentation
+	data    []byte
+	nodes   [][]byte
+	replace bool
+	// keepIndent keeps the indentation of an element replaced by no node, as it is shared with a next sibling
+	keepIndent bool
+}
+
+// segment is the output of an element transformed by a worker, followed by what the parser
+// produced after it while it was pending.
+type segment struct {
+	rendering
+
+	done   chan struct{}
+	cancel context.CancelFunc
+	err    error
+	// trailer holds the bytes written after the element, yields the elements returned by Next after it
+	trailer []byte
+	yields  []*XMLElement
+}
+
+// orderedOutput writes transformed elements in document order while their callbacks run concurrently.
+// Write, yield, dispatch and flush are called by the parser only, workers just complete their segment.
+type orderedOutput struct {
+	out      *bufio.Writer
+	indent   *indentWriter
+	emit     func(*XMLElement)
+	workers  chan struct{}
+	maxQueue int
+	buffered int
+
+	mutex sync.Mutex
+	queue []*segment
+	err   error
+}
+
+func newOrderedOutput(out *bufio.Writer, indent *indentWriter, workers int, emit func(*XMLElement)) *orderedOutput {
+	return &orderedOutput{
+		out:      out,
+		indent:   indent,
+		emit:     emit,
+		workers:  make(chan struct{}, workers),
+		maxQueue: 2 * workers,
+	}
+}
+
+// Write passes p to the output, or holds it after the last pending element.
+func (o *orderedOutput) Write(p []byte) (int, error) {
+	if len(o.queue) == 0 {
+		return o.out.Write(p)
+	}
+
+	tail := o.queue[len(o.queue)-1]
+	tail.trailer = append(tail.trailer, p...)
+	o.buffered += len(p)
+
+	if o.buffered > maxBufferedOutput {
+		if err := o.flush(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// yield emits an element for Next, after the pending elements.
+func (o *orderedOutput) yield(element *XMLElement) {
+	if len(o.queue) == 0 {
+		o.emit(element)
+
+		return
+	}
+
+	tail := o.queue[len(o.queue)-1]
+	tail.yields = append(tail.yields, element)
+}
+
+// dispatch runs work on a worker, its result being written after the pending elements.
+// The parser waits for the oldest elements while too many are pending.
+func (o *orderedOutput) dispatch(ctx context.Context, work func(context.Context) (rendering, error)) error {
+	for len(o.queue) >= o.maxQueue {
+		if err := o.flushHead(); err != nil {
+			return err
+		}
+	}
+
+	if err := o.failure(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pending := &segment{done: make(chan struct{}), cancel: cancel}
+
+	o.mutex.Lock()
+	o.queue = append(o.queue, pending)
+	o.mutex.Unlock()
+
+	go func() {
+		defer close(pending.done)
+
+		o.workers <- struct{}{}
+		defer func() { <-o.workers }()
+
+		pending.rendering, pending.err = work(ctx)
+		if pending.err != nil {
+			o.fail(pending.err)
+		}
+	}()
+
+	return nil
+}
+
+// flush writes the completed elements at the head of the queue, waiting for all of them when wait is true.
+func (o *orderedOutput) flush(wait bool) error {
+	for len(o.queue) > 0 {
+		if err := o.failure(); err != nil {
+			return err
+		}
+
+		if !wait {
+			select {
+			case <-o.queue[0].done:
+			default:
+				return nil
+			}
+		}
+
+		if err := o.flushHead(); err != nil {
+			return err
+		}
+	}
+
+	return o.failure()
+}
+
+// flushHead waits for the oldest pending element and writes it with its trailer.
+func (o *orderedOutput) flushHead() error {
+	head := o.queue[0]
+
+	<-head.done
+
+	if head.err != nil {
+		return o.failure()
+	}
+
+	if err := o.write(head.rendering); err != nil {
+		return err
+	}
+
+	for _, element := range head.elements {
+		o.emit(element)
+	}
+
+	if _, err := o.out.Write(head.trailer); err != nil {
+		return err
+	}
+
+	for _, element := range head.yields {
+		o.emit(element)
+	}
+
+	o.buffered -= len(head.trailer)
+	head.cancel()
+
+	o.mutex.Lock()
+	o.queue[0] = nil
+	o.queue = o.queue[1:]
+	o.mutex.Unlock()
+
+	return nil
+}
+
+// write writes the output of a worker, the bytes before the element being flushed to be replaced with it.
+func (o *orderedOutput) write(output rendering) error {
+	if !output.replace {
+		_, err := o.out.Write(output.data)
+
+		return err
+	}
+
+	if err := o.out.Flush(); err != nil {
+		return err
+	}
+
+	return replaceElement(o.out, o.indent, output.nodes, output.keepIndent)
+}
+
+// abort cancels the pending elements and waits for their workers.
+func (o *orderedOutput) abort() {
+	o.mutex.Lock()
+	queue := o.queue
+	o.queue = nil
+	o.mutex.Unlock()
+
+	for _, pending := range queue {
+		pending.cancel()
+	}
+
+	for _, pending := range queue {
+		<-pending.done
+	}
+}
+
+// fail records the first error of the workers and cancels the pending elements.
+func (o *orderedOutput) fail(err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.err != nil {
+		return
+	}
+
+	o.err = err
+
+	for _, pending := range o.queue {
+		pending.cancel()
+	}
+}
+
+// failure returns the first error of the workers.
+func (o *orderedOutput) failure() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.err
+}
@@ -0,0 +1,647 @@
+package xixo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JSONConvention selects how an element tree is represented in JSON by XMLElementToJSONTreeCallback.
+// In every convention children sharing a name are gathered in an array, in document order.
+type JSONConvention int
+
+const (
+	// AttrTextConvention writes attributes as "@name" members and text as a "#text" member,
+	// an element without attributes nor children being its text: {"root":{"@id":"1","name":"joe"}}.
+	AttrTextConvention JSONConvention = iota
+	// BadgerFishConvention writes attributes as "@name" members and text as a "$" member,
+	// every element being an object: {"root":{"@id":"1","name":{"$":"joe"}}}.
+	BadgerFishConvention
+	// GDataConvention writes attributes as plain members and text as a "$t" member:
+	// {"root":{"id":"1","name":{"$t":"joe"}}}.
+	GDataConvention
+	// ParkerConvention leaves out the name of the root and the attributes, an element being its text,
+	// or the object of its children, or null when empty: {"name":"joe"}. Attributes are not written back.
+	ParkerConvention
+)
+
+// JSONType is the type of a value in the JSON given to JSON callbacks.
+type JSONType int
+
+const (
+	// JSONString writes the value as a string, the default.
+	JSONString JSONType = iota
+	// JSONNumber writes the value as a number, as it is written in the XML.
+	JSONNumber
+	// JSONBoolean writes true and false as booleans.
+	JSONBoolean
+)
+
+// XSINamespaceURI is the namespace of the XML Schema instance attributes, as xsi:nil.
+const XSINamespaceURI = "http://www.w3.org/2001/XMLSchema-instance"
+
+const xsiNil = "xsi:nil"
+
+// JSONOptions configures the JSON given to and read from JSON callbacks. Numbers and booleans returned
+// by a callback are written as they are in the JSON, null as an empty element or a removed attribute.
+type JSONOptions struct {
+	// Convention is the representation of the element tree, for tree callbacks.
+	Convention JSONConvention
+	// Types gives the type of values by key: the path of an element from the matched one without indexes,
+	// like address/zip, followed by @name for an attribute. A value that is not valid for its type, as an
+	// empty text for a number, is written as a string, or as null when empty.
+	Types map[string]JSONType
+	// XSINil writes null as an element with the attribute xsi:nil="true", and reads such an element as null.
+	XSINil bool
+}
+
+// typed returns the JSON value of the text at key.
+func (options JSONOptions) typed(key string, text string, null bool) any {
+	if null {
+		return nil
+	}
+
+	switch options.Types[stripIndexes(key)] {
+	case JSONNumber:
+		if isJSONNumber(text) {
+			return json.Number(text)
+		}
+	case JSONBoolean:
+		if value, err := strconv.ParseBool(text); err == nil && (text == "true" || text == "false") {
+			return value
+		}
+	case JSONString:
+		return text
+	}
+
+	if text == "" {
+		return nil
+	}
+
+	return text
+}
+
+// isJSONNumber reports whether text is a number in JSON syntax.
+func isJSONNumber(text string) bool {
+	if text == "" || (text[0] != '-' && (text[0] < '0' || text[0] > '9')) {
+		return false
+	}
+
+	return json.Valid([]byte(text))
+}
+
+// stripIndexes removes the indexes of the steps of a key: a[1]/b[0]@c becomes a/b@c.
+func stripIndexes(key string) string {
+	if !strings.Contains(key, "[") {
+		return key
+	}
+
+	var builder strings.Builder
+
+	for {
+		open := strings.IndexByte(key, '[')
+		end := strings.IndexByte(key, ']')
+
+		if open < 0 || end < open {
+			builder.WriteString(key)
+
+			return builder.String()
+		}
+
+		builder.WriteString(key[:open])
+		key = key[end+1:]
+	}
+}
+
+// scalarText returns the text of a scalar JSON value, and whether it is null.
+func scalarText(value any) (string, bool, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", true, true
+	case string:
+		return value, false, true
+	case json.Number:
+		return value.String(), false, true
+	case bool:
+		return strconv.FormatBool(value), false, true
+	}
+
+	return "", false, false
+}
+
+// jsonObject is a JSON object keeping the order of its members.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value any
+}
+
+func (object jsonObject) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+
+	buffer.WriteByte('{')
+
+	for i, member := range object {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		key, err := json.Marshal(member.key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(member.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
+
+// add appends a member, gathering the values of a repeated key in an array.
+func (object jsonObject) add(key string, value any, repeated bool) jsonObject {
+	for i, member := range object {
+		if member.key != key || !repeated {
+			continue
+		}
+
+		if array, ok := member.value.([]any); ok {
+			object[i].value = append(array, value)
+		} else {
+			object[i].value = []any{member.value, value}
+		}
+
+		return object
+	}
+
+	return append(object, jsonMember{key: key, value: value})
+}
+
+// textKey returns the member holding the text of an element in the convention.
+func (convention JSONConvention) textKey() string {
+	switch convention {
+	case BadgerFishConvention:
+		return "$"
+	case GDataConvention:
+		return "$t"
+	case AttrTextConvention, ParkerConvention:
+	}
+
+	return "#text"
+}
+
+// attrKey returns the member holding the attribute name in the convention.
+func (convention JSONConvention) attrKey(name string) string {
+	if convention == GDataConvention {
+		return name
+	}
+
+	return "@" + name
+}
+
+// elementToJSON returns the JSON representation of the element in the convention.
+func (options JSONOptions) elementToJSON(element *XMLElement) any {
+	if options.Convention == ParkerConvention {
+		return options.valueToJSON(element, "")
+	}
+
+	return jsonObject{{key: element.Name, value: options.valueToJSON(element, "")}}
+}
+
+// valueToJSON returns the JSON value of the element at path from the matched element.
+func (options JSONOptions) valueToJSON(element *XMLElement, path string) any {
+	convention := options.Convention
+	children := element.Children()
+	text := directText(element)
+
+	// whitespace between children is only indentation
+	if len(children) > 0 && strings.TrimSpace(text) == "" {
+		text = ""
+	}
+
+	if options.XSINil && element.Attrs[xsiNil].Value == "true" {
+		return nil
+	}
+
+	switch {
+	case convention == ParkerConvention && len(children) == 0 && text == "":
+		return nil
+	case convention == ParkerConvention && len(children) == 0,
+		convention == AttrTextConvention && len(children) == 0 && len(element.AttrKeys) == 0:
+		return options.typed(path, text, false)
+	}
+
+	object := jsonObject{}
+
+	if convention != ParkerConvention {
+		for _, key := range element.AttrKeys {
+			value := options.typed(path+"@"+key, element.Attrs[key].Value, false)
+			object = append(object, jsonMember{key: convention.attrKey(key), value: value})
+		}
+	}
+
+	counts := map[string]int{}
+
+	for _, child := range children {
+		counts[child.Name]++
+	}
+
+	for _, child := range children {
+		childPath := child.Name
+		if path != "" {
+			childPath = path + "/" + child.Name
+		}
+
+		object = object.add(child.Name, options.valueToJSON(child, childPath), counts[child.Name] > 1)
+	}
+
+	if text != "" && convention != ParkerConvention {
+		object = append(object, jsonMember{key: convention.textKey(), value: options.typed(path, text, false)})
+	}
+
+	return object
+}
+
+// memberKind is what a member of the JSON object of an element holds.
+type memberKind int
+
+const (
+	childMember memberKind = iota
+	attributeMember
+	textMember
+)
+
+// memberKind tells whether a member of the object of an element holds its text, an attribute or children.
+func (convention JSONConvention) memberKind(member jsonMember) memberKind {
+	_, null, scalar := scalarText(member.value)
+
+	switch {
+	case convention == ParkerConvention:
+		return childMember
+	case member.key == convention.textKey():
+		return textMember
+	case convention == GDataConvention && scalar && !null,
+		convention != GDataConvention && strings.HasPrefix(member.key, "@"):
+		return attributeMember
+	}
+
+	return childMember
+}
+
+// mergeIntoElement applies the JSON representation of the element in the convention onto it: texts,
+// attributes and children are changed in place, keeping the indentation and comments of the input,
+// and created or removed as the JSON requires.
+func (options JSONOptions) mergeIntoElement(element *XMLElement, value any) error {
+	if options.Convention != ParkerConvention {
+		object, ok := value.(jsonObject)
+		if !ok || len(object) != 1 {
+			return fmt.Errorf("%w: expected an object with the root element as single member", ErrUnsupportedJSONValue)
+		}
+
+		if !isXMLName(object[0].key) {
+			return fmt.Errorf("%w: invalid element name %q", ErrUnsupportedJSONValue, object[0].key)
+		}
+
+		element.Name, value = object[0].key, object[0].value
+	}
+
+	nilled := false
+
+	err := options.mergeValue(element, value, &nilled)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := element.Attrs["xmlns:xsi"]; nilled && !ok {
+		element.AddAttribute(Attribute{Name: "xmlns:xsi", Value: XSINamespaceURI})
+	}
+
+	return nil
+}
+
+// mergeValue applies the JSON value of an element onto it, nilled being set when xsi:nil is used.
+func (options JSONOptions) mergeValue(element *XMLElement, value any, nilled *bool) error {
+	object, ok := value.(jsonObject)
+	if !ok {
+		return options.mergeScalar(element, value, nilled)
+	}
+
+	convention := options.Convention
+	attributes, children := jsonObject{}, jsonObject{}
+	text := ""
+
+	for _, member := range object {
+		memberText, _, scalar := scalarText(member.value)
+
+		switch convention.memberKind(member) {
+		case textMember:
+			if !scalar {
+				return fmt.Errorf("%w: %s for the text of %s", ErrUnsupportedJSONValue, jsonKind(member.value), element.Name)
+			}
+
+			text = memberText
+		case attributeMember:
+			name := member.key
+			if convention != GDataConvention {
+				name = strings.TrimPrefix(name, "@")
+			}
+
+			if !isXMLName(name) {
+				return fmt.Errorf("%w: invalid attribute name %q", ErrUnsupportedJSONValue, name)
+			}
+
+			if !scalar {
+				return fmt.Errorf("%w: %s for attribute %s", ErrUnsupportedJSONValue, jsonKind(member.value), name)
+			}
+
+			attributes = append(attributes, jsonMember{key: name, value: member.value})
+		case childMember:
+			if !isXMLName(member.key) {
+				return fmt.Errorf("%w: invalid element name %q", ErrUnsupportedJSONValue, member.key)
+			}
+
+			children = append(children, member)
+		}
+	}
+
+	if options.XSINil {
+		element.RemoveAttribute(xsiNil)
+	}
+
+	if convention != ParkerConvention {
+		mergeAttributes(element, attributes)
+	}
+
+	err := options.mergeChildren(element, children, nilled)
+	if err != nil {
+		return err
+	}
+
+	// the Parker convention has no text for elements with children
+	if convention != ParkerConvention {
+		setText(element, text)
+	}
+
+	return nil
+}
+
+// mergeScalar applies the scalar JSON value of an element onto it: the element is left with this
+// text only, its attributes being kept in the Parker convention. used is set when xsi:nil is used.
+func (options JSONOptions) mergeScalar(element *XMLElement, value any, used *bool) error {
+	text, null, ok := scalarText(value)
+	if !ok {
+		return fmt.Errorf("%w: %s for %s", ErrUnsupportedJSONValue, jsonKind(value), element.Name)
+	}
+
+	nilled := null && options.XSINil
+
+	element.removeChildren(func(*XMLElement) bool { return true })
+
+	// a scalar stands for an element without attributes, but a nil one hides them
+	if options.Convention != ParkerConvention && !nilled {
+		for _, name := range slices.Clone(element.AttrKeys) {
+			if name != "xmlns" && !strings.HasPrefix(name, "xmlns:") {
+				element.RemoveAttribute(name)
+			}
+		}
+	}
+
+	if options.XSINil {
+		element.RemoveAttribute(xsiNil)
+	}
+
+	if nilled {
+		element.AddAttribute(Attribute{Name: xsiNil, Value: "true"})
+
+		*used = true
+	}
+
+	setText(element, text)
+
+	return nil
+}
+
+// mergeAttributes sets the attributes of the element from the JSON members, removing the others.
+func mergeAttributes(element *XMLElement, attributes jsonObject) {
+	kept := map[string]bool{}
+
+	for _, member := range attributes {
+		text, null, _ := scalarText(member.value)
+		if null {
+			continue
+		}
+
+		kept[member.key] = true
+
+		if attribute, ok := element.Attrs[member.key]; !ok || attribute.Value != text {
+			element.AddAttribute(Attribute{Name: member.key, Value: text})
+		}
+	}
+
+	for _, name := range slices.Clone(element.AttrKeys) {
+		if !kept[name] {
+			element.RemoveAttribute(name)
+		}
+	}
+}
+
+// mergeChildren applies the JSON members holding children onto the children of the element: the children
+// of a name are merged in order with its values, the missing ones being created and the others removed.
+func (options JSONOptions) mergeChildren(element *XMLElement, members jsonObject, nilled *bool) error {
+	names := []string{}
+	values := map[string][]any{}
+
+	for _, member := range members {
+		if _, ok := values[member.key]; !ok {
+			names = append(names, member.key)
+		}
+
+		if array, ok := member.value.([]any); ok {
+			values[member.key] = append(values[member.key], array...)
+		} else {
+			values[member.key] = append(values[member.key], member.value)
+		}
+	}
+
+	element.removeChildren(func(child *XMLElement) bool {
+		_, ok := values[child.Name]
+
+		return !ok
+	})
+
+	for _, name := range names {
+		existing := element.ChildrenNamed(name)
+
+		for i, value := range values[name] {
+			if i < len(existing) {
+				if err := options.mergeValue(existing[i], value, nilled); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			child := NewXMLElement()
+			child.Name = name
+
+			if err := options.mergeValue(child, value, nilled); err != nil {
+				return err
+			}
+
+			element.insertChild(child)
+		}
+
+		if surplus := existing[min(len(existing), len(values[name])):]; len(surplus) > 0 {
+			element.removeChildren(func(child *XMLElement) bool { return slices.Contains(surplus, child) })
+		}
+	}
+
+	return nil
+}
+
+// setText replaces the character data of the element with text. Around children, the whitespace indenting
+// them is kept and the text takes the place of the first text it replaces.
+func setText(element *XMLElement, text string) {
+	current := directText(element)
+
+	if len(element.Children()) == 0 {
+		if current != text {
+			element.InnerText = text
+		}
+
+		return
+	}
+
+	// whitespace between children is only indentation
+	if current == text || (text == "" && strings.TrimSpace(current) == "") {
+		return
+	}
+
+	element.editContent(func() {
+		nodes := make([]*Node, 0, len(element.nodes)+1)
+		placed := text == ""
+
+		for _, node := range element.nodes {
+			if (node.Kind != TextNode && node.Kind != CDATANode) || node.isBlank() {
+				nodes = append(nodes, node)
+
+				continue
+			}
+
+			// the text takes the place of the first text replaced, within its indentation
+			if !placed {
+				trimmed := strings.TrimSpace(node.Data)
+				start := strings.Index(node.Data, trimmed)
+				data := node.Data[:start] + strings.TrimSpace(text) + node.Data[start+len(trimmed):]
+
+				nodes = append(nodes, &Node{Kind: TextNode, Data: data})
+				placed = true
+			}
+		}
+
+		if !placed {
+			nodes = append(nodes, &Node{Kind: TextNode, Data: text})
+		}
+
+		element.nodes = nodes
+	})
+}
+
+// jsonKind returns the JSON type of a decoded value, for error messages.
+func jsonKind(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	case jsonObject:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+// decodeJSON decodes a single JSON value, objects keeping the order of their members and numbers
+// their text.
+func decodeJSON(source string) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(source))
+	decoder.UseNumber()
+
+	value, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: data after the JSON value", ErrUnsupportedJSONValue)
+	}
+
+	return value, nil
+}
+
+func decodeJSONValue(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			object = append(object, jsonMember{key: key.(string), value: value}) //nolint:forcetypeassert
+		}
+
+		_, err = decoder.Token()
+
+		return object, err
+	case json.Delim('['):
+		array := []any{}
+
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+
+		_, err = decoder.Token()
+
+		return array, err
+	}
+
+	return token, nil
+}
@@ -1,7 +1,10 @@
 package xixo
 
 import (
+	"context"
 	"io"
+	"maps"
+	"slices"
 )
 
 // Driver represents a driver that processes XML using callback functions.
@@ -10,19 +13,37 @@ type Driver struct {
 }
 
 // NewDriver creates a new FuncDriver instance with the given reader, writer, and callbacks.
+// Callbacks are keyed by path expressions, as accepted by XMLParser.Register.
 func NewDriver(reader io.Reader, writer io.Writer, callbacks map[string]CallbackMap) Driver {
-	// Create a new XML parser with XPath enabled.
-	parser := NewXMLParser(reader, writer).EnableXpath()
+	// Create a new XML parser.
+	parser := NewXMLParser(reader, writer)
 
-	// Register callback functions for each element.
-	for elementName, function := range callbacks {
-		parser.RegisterMapCallback(elementName, function)
-	}
+	// Register callback functions for each path.
+	registerSorted(callbacks, parser.RegisterMapCallback)
 
 	// Return the FuncDriver with the parser.
 	return Driver{parser: parser}
 }
 
+// NewDriverContext creates a new Driver with context-aware callbacks, see NewDriver and Driver.StreamContext.
+func NewDriverContext(reader io.Reader, writer io.Writer, callbacks map[string]CallbackMapContext) Driver {
+	parser := NewXMLParser(reader, writer)
+
+	registerSorted(callbacks, func(match string, callback CallbackMapContext) {
+		parser.Register(match, XMLElementToMapCallbackContext(callback))
+	})
+
+	return Driver{parser: parser}
+}
+
+// registerSorted registers the callbacks keyed by path expressions in the order of the expressions,
+// to be stable when several of them select the same element.
+func registerSorted[C any](callbacks map[string]C, register func(match string, callback C)) {
+	for _, match := range slices.Sorted(maps.Keys(callbacks)) {
+		register(match, callbacks[match])
+	}
+}
+
 // Stream processes the XML using registered callback functions and returns any error encountered.
 func (d Driver) Stream() error {
 	// Stream the XML using the parser and return any error encountered.
@@ -30,3 +51,9 @@ func (d Driver) Stream() error {
 
 	return err
 }
+
+// StreamContext processes the XML like Stream, until the context is done. The context is passed to
+// context-aware callbacks, see XMLParser.StreamContext.
+func (d Driver) StreamContext(ctx context.Context) error {
+	return d.parser.StreamContext(ctx)
+}
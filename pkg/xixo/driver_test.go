@@ -2,6 +2,7 @@
 
 import (
 	"bytes"
+	"context"
 	"testing"
 
 	"github.com/CGI-FR/xixo/pkg/xixo"
@@ -108,3 +109,52 @@ func TestFuncDriverEdit2subscribers(t *testing.T) {
 		</root>`
 	assert.Equal(t, expected, writer.String())
 }
+
+func TestFuncDriverEditWithPath(t *testing.T) {
+	t.Parallel()
+
+	reader := bytes.NewBufferString(
+		`<root><customer><address><city>Nantes</city></address></customer>` +
+			`<supplier><address><city>Paris</city></address></supplier></root>`,
+	)
+	writer := bytes.Buffer{}
+
+	subscribers := map[string]xixo.CallbackMap{
+		"customer/address": func(input map[string]string) (map[string]string, error) {
+			input["city"] = "Rennes"
+
+			return input, nil
+		},
+	}
+
+	driver := xixo.NewDriver(reader, &writer, subscribers)
+
+	err := driver.Stream()
+	assert.Nil(t, err)
+
+	expected := `<root><customer><address><city>Rennes</city></address></customer>` +
+		`<supplier><address><city>Paris</city></address></supplier></root>`
+	assert.Equal(t, expected, writer.String())
+}
+
+type driverContextKey struct{}
+
+func TestFuncDriverStreamContext(t *testing.T) {
+	t.Parallel()
+
+	reader := bytes.NewBufferString("<root><element1>innerTexta</element1></root>")
+	writer := bytes.Buffer{}
+	callback := func(ctx context.Context, input map[string]string) (map[string]string, error) {
+		input["element1"], _ = ctx.Value(driverContextKey{}).(string)
+
+		return input, nil
+	}
+
+	subscribers := map[string]xixo.CallbackMapContext{"root": callback}
+	driver := xixo.NewDriverContext(reader, &writer, subscribers)
+
+	err := driver.StreamContext(context.WithValue(context.Background(), driverContextKey{}, "fromContext"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "<root><element1>fromContext</element1></root>", writer.String())
+}
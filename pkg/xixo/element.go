@@ -2,6 +2,8 @@
 
 import (
 	"fmt"
+	"io"
+	"slices"
 	"strings"
 )
 
@@ -26,12 +28,26 @@ type Attribute struct {
 	Quote Quote
 }
 
+// Prefix returns the namespace prefix of the attribute name, if any.
+func (attr Attribute) Prefix() string {
+	prefix, _ := splitName(attr.Name)
+
+	return prefix
+}
+
+// LocalName returns the attribute name without its namespace prefix.
+func (attr Attribute) LocalName() string {
+	_, local := splitName(attr.Name)
+
+	return local
+}
+
 func (attr Attribute) String() string {
 	if attr.Quote == SimpleQuote {
-		return fmt.Sprintf("%s='%s'", attr.Name, attr.Value)
+		return fmt.Sprintf("%s='%s'", attr.Name, escapeAttr(attr.Value, SimpleQuote))
 	}
 
-	return fmt.Sprintf("%s=\"%s\"", attr.Name, attr.Value)
+	return fmt.Sprintf("%s=\"%s\"", attr.Name, escapeAttr(attr.Value, DoubleQuotes))
 }
 
 type XMLElement struct {
@@ -39,74 +55,540 @@ type XMLElement struct {
 	Attrs     map[string]Attribute
 	AttrKeys  []string
 	InnerText string
-	Childs    map[string][]XMLElement
 	Err       error
 
-	// filled when xpath enabled
-	childs    []*XMLElement
-	parent    *XMLElement
-	localName string
-	prefix    string
+	// nodes is the only storage of the content, children included, in document order
+	nodes  []*Node
+	parent *XMLElement
 
-	outerTextBefore string
-	autoClosable    bool
+	namespaceURI string
+	// attrURIs holds the namespace URIs of the attributes resolved while parsing, by attribute name
+	attrURIs     map[string]string
+	depth        int
+	autoClosable bool
+	// source holds the original text of the tags, nil for an element built by program
+	source *tagSource
+	// contentParsed is true when the content was read from the input
+	contentParsed bool
 }
 
-func (n *XMLElement) FirstChild() *XMLElement {
-	if n.childs == nil {
-		return nil
+// Prefix returns the namespace prefix of the element name, if any.
+func (n *XMLElement) Prefix() string {
+	prefix, _ := splitName(n.Name)
+
+	return prefix
+}
+
+// LocalName returns the element name without its namespace prefix.
+func (n *XMLElement) LocalName() string {
+	_, local := splitName(n.Name)
+
+	return local
+}
+
+// NamespaceURI returns the namespace URI resolved from the element prefix
+// and the xmlns declarations in scope while parsing.
+func (n *XMLElement) NamespaceURI() string {
+	return n.namespaceURI
+}
+
+// AttributeNamespaceURI returns the namespace URI resolved from the prefix of the named attribute
+// while parsing, empty for an unprefixed attribute.
+func (n *XMLElement) AttributeNamespaceURI(name string) string {
+	return n.attrURIs[name]
+}
+
+// Depth returns the depth of the element in the document, the document element being at depth 1.
+func (n *XMLElement) Depth() int {
+	return n.depth
+}
+
+// ExpandedName returns the element name in {uri}local notation,
+// or the local name alone when the element has no namespace.
+func (n *XMLElement) ExpandedName() string {
+	return expandedName(n.namespaceURI, n.LocalName())
+}
+
+// Parent returns the element containing this one, or nil.
+func (n *XMLElement) Parent() *XMLElement {
+	return n.parent
+}
+
+// Children returns the children of the element in document order.
+func (n *XMLElement) Children() []*XMLElement {
+	var children []*XMLElement
+
+	for _, node := range n.nodes {
+		if node.Kind == ElementNode && node.Element != nil {
+			children = append(children, node.Element)
+		}
 	}
 
-	if len(n.childs) > 0 {
-		return n.childs[0]
+	return children
+}
+
+// Child returns the first child named name, or nil.
+func (n *XMLElement) Child(name string) *XMLElement {
+	for _, node := range n.nodes {
+		if node.Kind == ElementNode && node.Element != nil && node.Element.Name == name {
+			return node.Element
+		}
 	}
 
 	return nil
 }
 
-func (n *XMLElement) NextSibling() *XMLElement {
-	if n.parent != nil {
-		for i, c := range n.parent.childs {
-			if c == n {
-				if i+1 < len(n.parent.childs) {
-					return n.parent.childs[i+1]
-				}
-
-				return nil
+// ChildrenNamed returns the children named name in document order.
+func (n *XMLElement) ChildrenNamed(name string) []*XMLElement {
+	var children []*XMLElement
+
+	for _, child := range n.Children() {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// Childs groups the children by name, in document order for each name. The map is built on each
+// call from the content of the element, the elements are shared with it.
+//
+// Deprecated: Childs replaces the removed Childs field. Use Child, ChildrenNamed or Children instead.
+func (n *XMLElement) Childs() map[string][]*XMLElement {
+	childs := map[string][]*XMLElement{}
+
+	for _, child := range n.Children() {
+		childs[child.Name] = append(childs[child.Name], child)
+	}
+
+	return childs
+}
+
+// Nodes returns the content of the element in document order: children, text, comments and CDATA sections.
+func (n *XMLElement) Nodes() []*Node {
+	return n.nodes
+}
+
+// AppendNode adds node at the end of the content of the element.
+func (n *XMLElement) AppendNode(node *Node) {
+	if node.Kind == ElementNode && node.Element != nil {
+		node.Element.parent = n
+	}
+
+	n.editContent(func() { n.nodes = append(n.nodes, node) })
+}
+
+// insertChild adds child after the last child of the same name, or else after the last child,
+// with the indentation preceding this sibling.
+func (n *XMLElement) insertChild(child *XMLElement) {
+	child.parent = n
+
+	sibling, sameName := -1, -1
+
+	for i, node := range n.nodes {
+		if node.Kind == ElementNode && node.Element != nil {
+			sibling = i
+
+			if node.Element.Name == child.Name {
+				sameName = i
 			}
 		}
 	}
 
+	if sameName >= 0 {
+		sibling = sameName
+	}
+
+	inserted := []*Node{{Kind: ElementNode, Element: child}}
+
+	if sibling < 0 {
+		n.editContent(func() { n.nodes = append(n.nodes, inserted...) })
+
+		return
+	}
+
+	if sibling > 0 {
+		if indent := n.nodes[sibling-1]; indent.Kind == TextNode && strings.TrimSpace(indent.Data) == "" {
+			inserted = append([]*Node{{Kind: TextNode, Data: indent.Data, raw: indent.raw}}, inserted...)
+		}
+	}
+
+	n.editContent(func() { n.nodes = slices.Insert(n.nodes, sibling+1, inserted...) })
+}
+
+// editContent applies edit to the nodes, keeping the InnerText of a parsed element in line with them
+// unless it was assigned.
+func (n *XMLElement) editContent(edit func()) {
+	inLine := n.contentParsed && n.InnerText == trailingText(n.nodes)
+
+	edit()
+
+	if inLine {
+		n.InnerText = trailingText(n.nodes)
+	}
+}
+
+// appendIndented appends the nodes taking the place of a child being read: the whitespace indenting
+// the child is removed when there is no node, unless keepIndent is set, and repeated before each node
+// when there are several.
+func (n *XMLElement) appendIndented(nodes []*Node, keepIndent bool) {
+	if child := singleElement(nodes); child != nil {
+		n.AppendChild(child)
+
+		return
+	}
+
+	if len(nodes) == 0 && keepIndent {
+		return
+	}
+
+	n.editContent(func() {
+		var indent *Node
+
+		if last := len(n.nodes) - 1; last >= 0 && n.nodes[last].isBlank() {
+			indent = n.nodes[last]
+			n.nodes = n.nodes[:last]
+		}
+
+		for _, node := range nodes {
+			if indent != nil {
+				n.nodes = append(n.nodes, &Node{Kind: TextNode, Data: indent.Data, raw: indent.raw})
+			}
+
+			if node.Kind == ElementNode && node.Element != nil {
+				node.Element.parent = n
+			}
+
+			n.nodes = append(n.nodes, node)
+		}
+	})
+}
+
+// AppendChild adds child as the last child of the element.
+func (n *XMLElement) AppendChild(child *XMLElement) {
+	n.AppendNode(&Node{Kind: ElementNode, Element: child})
+}
+
+func (n *XMLElement) FirstChild() *XMLElement {
+	for _, node := range n.nodes {
+		if node.Kind == ElementNode && node.Element != nil {
+			return node.Element
+		}
+	}
+
 	return nil
 }
 
+func (n *XMLElement) NextSibling() *XMLElement {
+	if n.parent == nil {
+		return nil
+	}
+
+	found := false
+
+	for _, node := range n.parent.nodes {
+		if node.Kind != ElementNode || node.Element == nil {
+			continue
+		}
+
+		if found {
+			return node.Element
+		}
+
+		found = node.Element == n
+	}
+
+	return nil
+}
+
+// content returns the nodes to write and the text following them: a parsed element whose InnerText
+// was assigned is written with the new text only.
+func (n *XMLElement) content() ([]*Node, string) {
+	if !n.contentParsed {
+		return n.nodes, n.InnerText
+	}
+
+	if n.InnerText != trailingText(n.nodes) {
+		return nil, n.InnerText
+	}
+
+	return n.nodes, ""
+}
+
+// textInCDATA reports whether the text read after the last child was only CDATA sections, an assigned
+// InnerText being then written in a CDATA section too.
+func (n *XMLElement) textInCDATA() bool {
+	cdata := false
+
+	for i := len(n.nodes) - 1; i >= 0 && n.nodes[i].Kind != ElementNode; i-- {
+		if n.nodes[i].Kind != CDATANode {
+			return false
+		}
+
+		cdata = true
+	}
+
+	return cdata
+}
+
 func (n *XMLElement) String() string {
-	xmlChilds := ""
+	var builder strings.Builder
+
+	_, _ = n.WriteTo(&builder)
+
+	return builder.String()
+}
+
+// WriteTo writes the element and its children as XML to w, implementing io.WriterTo.
+// Contrary to String, the serialization is streamed to w without building intermediate strings.
+func (n *XMLElement) WriteTo(w io.Writer) (int64, error) {
+	enc := encoder{w: w}
+
+	n.encode(&enc, true)
+
+	return enc.n, enc.err
+}
+
+// encoder writes strings to a writer, keeping the count of bytes written and the first error.
+type encoder struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (enc *encoder) writeString(s string) {
+	if enc.err != nil {
+		return
+	}
+
+	n, err := io.WriteString(enc.w, s)
+	enc.n += int64(n)
+	enc.err = err
+}
+
+// encode writes the element, without the opening '<' of its start tag when open is false.
+func (n *XMLElement) encode(enc *encoder, open bool) {
+	nodes, text := n.content()
+	selfClosing := n.autoClosable && text == "" && len(nodes) == 0
+
+	n.encodeStartTag(enc, open, selfClosing)
+
+	if selfClosing {
+		return
+	}
+
+	for _, node := range nodes {
+		node.encode(enc)
+	}
+
+	if text != "" && n.contentParsed && n.textInCDATA() {
+		(&Node{Kind: CDATANode, Data: text}).encode(enc)
+	} else {
+		enc.writeString(escapeText(text))
+	}
+
+	if n.source != nil && n.source.end != "" && n.Name == n.source.name {
+		enc.writeString(n.source.end)
+
+		return
+	}
+
+	enc.writeString("</")
+	enc.writeString(n.Name)
+	enc.writeString(">")
+}
+
+// encodeStartTag writes the start tag, as it was read when the name and attributes are unchanged.
+// Otherwise, only the name and the attributes that changed are written again, the others being written
+// as they were read, with the whitespace preceding them.
+func (n *XMLElement) encodeStartTag(enc *encoder, open bool, selfClosing bool) {
+	if n.source.unchanged(n) && n.source.selfClosing == selfClosing {
+		if open {
+			enc.writeString(n.source.start)
+		} else {
+			enc.writeString(n.source.start[1:])
+		}
+
+		return
+	}
+
+	parts, split := n.source.split()
+
+	if open {
+		enc.writeString("<")
+	}
+
+	if split && n.Name == n.source.name {
+		enc.writeString(parts.head[1:])
+	} else {
+		enc.writeString(n.Name)
+	}
+
+	for _, key := range n.AttrKeys {
+		attr := n.Attrs[key]
+
+		raw, ok := parts.attrs[key]
+		if ok && n.source.readWith(attr) {
+			enc.writeString(raw)
+
+			continue
+		}
+
+		if ok {
+			// a changed attribute keeps the whitespace preceding it
+			enc.writeString(raw[:skipWS(raw, 0)])
+		} else {
+			enc.writeString(" ")
+		}
+
+		enc.writeString(attr.Name)
+
+		if attr.Quote == SimpleQuote {
+			enc.writeString("='")
+			enc.writeString(escapeAttr(attr.Value, SimpleQuote))
+			enc.writeString("'")
+		} else {
+			enc.writeString("=\"")
+			enc.writeString(escapeAttr(attr.Value, DoubleQuotes))
+			enc.writeString("\"")
+		}
+	}
+
+	if split && selfClosing == n.source.selfClosing {
+		enc.writeString(parts.space)
+	}
 
-	for node := n.FirstChild(); node != nil; node = node.NextSibling() {
-		xmlChilds += node.String()
+	if selfClosing {
+		enc.writeString("/>")
+	} else {
+		enc.writeString(">")
 	}
+}
 
-	attributes := n.Name + " "
+// tagSource is the text of the tags of a parsed element as read, written back as long as the element
+// keeps the name and attributes it was read with.
+type tagSource struct {
+	start       string
+	end         string
+	selfClosing bool
+	name        string
+	attrs       []Attribute
+}
+
+// setStartTag records raw as the start tag of the element, with its current name and attributes.
+func (n *XMLElement) setStartTag(raw string) {
+	attrs := make([]Attribute, 0, len(n.AttrKeys))
 
 	for _, key := range n.AttrKeys {
-		attributes += n.Attrs[key].String() + " "
+		attrs = append(attrs, n.Attrs[key])
+	}
+
+	n.source = &tagSource{start: raw, selfClosing: n.autoClosable, name: n.Name, attrs: attrs}
+}
+
+// setEndTag records raw as the end tag of the element.
+func (n *XMLElement) setEndTag(raw string) {
+	if n.source != nil {
+		n.source.end = raw
+	}
+}
+
+// unchanged reports whether element has the name and attributes its start tag was read with.
+func (source *tagSource) unchanged(element *XMLElement) bool {
+	if source == nil || source.start == "" || element.Name != source.name || len(element.AttrKeys) != len(source.attrs) {
+		return false
+	}
+
+	for i, key := range element.AttrKeys {
+		attr, ok := element.Attrs[key]
+		if !ok || key != source.attrs[i].Name || attr != source.attrs[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readWith reports whether the start tag was read with the attribute.
+func (source *tagSource) readWith(attr Attribute) bool {
+	for _, read := range source.attrs {
+		if read.Name == attr.Name {
+			return read == attr
+		}
+	}
+
+	return false
+}
+
+// startTagParts is a start tag as read, split in the '<' and the name, the text of each attribute with
+// the whitespace preceding it, and the whitespace before the end of the tag, kept as long as the element
+// does not change from self-closing to having content or the reverse.
+type startTagParts struct {
+	head  string
+	attrs map[string]string
+	space string
+}
+
+// split splits the start tag as read, false when there is none or it holds markup the parser ignored.
+func (source *tagSource) split() (startTagParts, bool) {
+	if source == nil || source.start == "" {
+		return startTagParts{}, false
+	}
+
+	raw := source.start
+	pos := 1
+
+	for pos < len(raw) && !isWS(raw[pos]) && raw[pos] != '/' && raw[pos] != '>' {
+		pos++
 	}
 
-	attributes = strings.Trim(attributes, " ")
+	parts := startTagParts{head: raw[:pos], attrs: make(map[string]string, len(source.attrs))}
+
+	for {
+		start := pos
+		pos = skipWS(raw, pos)
+
+		if pos == len(raw) || raw[pos] == '/' || raw[pos] == '>' {
+			parts.space = raw[start:pos]
+
+			return parts, true
+		}
+
+		nameStart := pos
+		for pos < len(raw) && !isWS(raw[pos]) && raw[pos] != '=' {
+			pos++
+		}
+
+		name := raw[nameStart:pos]
+
+		pos = skipWS(raw, pos)
+		if pos == len(raw) || raw[pos] != '=' {
+			return startTagParts{}, false
+		}
 
-	if n.autoClosable && n.InnerText == "" && xmlChilds == "" {
-		return fmt.Sprintf("%s<%s/>",
-			n.outerTextBefore,
-			attributes)
+		pos = skipWS(raw, pos+1)
+		if pos == len(raw) || (raw[pos] != '"' && raw[pos] != '\'') {
+			return startTagParts{}, false
+		}
+
+		end := strings.IndexByte(raw[pos+1:], raw[pos])
+		if end < 0 {
+			return startTagParts{}, false
+		}
+
+		pos += end + 2
+		parts.attrs[name] = raw[start:pos]
+	}
+}
+
+// skipWS returns the position of the first byte of s from pos that is not whitespace.
+func skipWS(s string, pos int) int {
+	for pos < len(s) && isWS(s[pos]) {
+		pos++
 	}
 
-	return fmt.Sprintf("%s<%s>%s%s</%s>",
-		n.outerTextBefore,
-		attributes,
-		xmlChilds,
-		n.InnerText,
-		n.Name)
+	return pos
 }
 
 func (n *XMLElement) AddAttribute(attr Attribute) {
@@ -136,16 +618,33 @@ func (n *XMLElement) RemoveAttribute(name string) {
 	}
 }
 
+// RemoveChild removes every child named name, with the whitespace indenting it.
 func (n *XMLElement) RemoveChild(name string) {
-	delete(n.Childs, name)
-	// If xPath is enabled and element have childs
-	if len(n.childs) > 0 {
-		for i, child := range n.childs {
-			if child.Name == name {
-				n.childs = append(n.childs[:i], n.childs[i+1:]...)
+	n.removeChildren(func(child *XMLElement) bool { return child.Name == name })
+}
+
+// removeChildren removes the children selected by remove, with the whitespace indenting them.
+func (n *XMLElement) removeChildren(remove func(*XMLElement) bool) {
+	n.editContent(func() {
+		kept := n.nodes[:0]
+
+		for _, node := range n.nodes {
+			if node.Kind != ElementNode || node.Element == nil || !remove(node.Element) {
+				kept = append(kept, node)
+
+				continue
+			}
+
+			node.Element.parent = nil
+
+			if last := len(kept) - 1; last >= 0 && kept[last].isBlank() {
+				kept = kept[:last]
 			}
 		}
-	}
+
+		clear(n.nodes[len(kept):])
+		n.nodes = kept
+	})
 }
 
 func NewXMLElement() *XMLElement {
@@ -154,11 +653,7 @@ func NewXMLElement() *XMLElement {
 		Attrs:     map[string]Attribute{},
 		AttrKeys:  make([]string, 0),
 		InnerText: "",
-		Childs:    map[string][]XMLElement{},
 		Err:       nil,
-		childs:    []*XMLElement{},
 		parent:    nil,
-		localName: "",
-		prefix:    "",
 	}
 }
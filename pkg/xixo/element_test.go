@@ -2,6 +2,7 @@
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"testing"
 
@@ -269,3 +270,174 @@ func TestCallbackShouldRemoveTargetTagsAndTargetAttrsWithSameName(t *testing.T)
 	resultXML := resultXMLBuffer.String()
 	assert.Equal(t, expect, resultXML)
 }
+
+func TestAttributeStringShouldEscapeValue(t *testing.T) {
+	t.Parallel()
+
+	attr := xixo.Attribute{Name: "foo", Value: `a&b<c"d'e`, Quote: xixo.DoubleQuotes}
+	assert.Equal(t, `foo="a&amp;b&lt;c&quot;d'e"`, attr.String())
+
+	attr.Quote = xixo.SimpleQuote
+	assert.Equal(t, `foo='a&amp;b&lt;c"d&apos;e'`, attr.String())
+}
+
+func TestElementStringShouldEscapeInnerText(t *testing.T) {
+	t.Parallel()
+
+	root := xixo.NewXMLElement()
+	root.Name = parentTag
+	root.InnerText = "Tom & Jerry <3"
+
+	assert.Equal(t, "<root>Tom &amp; Jerry &lt;3</root>", root.String())
+}
+
+func TestElementWriteToShouldStreamXML(t *testing.T) {
+	t.Parallel()
+
+	rootXML := `<root id="1" label='a &amp; b'><name>Tom &amp; Jerry</name><empty/><list><item>1</item><item>2</item></list></root>`
+
+	root := createTreeFromXMLString(rootXML)
+
+	var buffer bytes.Buffer
+
+	n, err := root.WriteTo(&buffer)
+	assert.Nil(t, err)
+
+	assert.Equal(t, rootXML, buffer.String())
+	assert.Equal(t, int64(len(rootXML)), n)
+	assert.Equal(t, root.String(), buffer.String())
+}
+
+func TestElementWriteToShouldReturnWriterError(t *testing.T) {
+	t.Parallel()
+
+	errWrite := errors.New("disk full")
+	root := createTreeFromXMLString(`<root><name>Tom</name></root>`)
+
+	n, err := root.WriteTo(&failingWriter{limit: 8, err: errWrite})
+	assert.ErrorIs(t, err, errWrite)
+	assert.LessOrEqual(t, n, int64(8))
+}
+
+// failingWriter accepts limit bytes, then fails.
+type failingWriter struct {
+	limit int
+	err   error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, w.err
+	}
+
+	w.limit -= len(p)
+
+	return len(p), nil
+}
+
+func TestElementChildrenShouldShareOneStorage(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(`<root><name>a</name><phone>1</phone><name>b</name><phone>2</phone></root>`)
+
+	assert.Len(t, root.Children(), 4)
+	assert.Equal(t, "a", root.Child("name").InnerText)
+	assert.Nil(t, root.Child("missing"))
+	assert.Len(t, root.ChildrenNamed("phone"), 2)
+	assert.Len(t, root.Childs(), 2)
+
+	// mutations through any accessor are visible in the others and in the output
+	root.Childs()["phone"][1].InnerText = "3"
+	root.ChildrenNamed("name")[0].InnerText = "c"
+
+	assert.Equal(t, "3", root.Children()[3].InnerText)
+	assert.Equal(t, `<root><name>c</name><phone>1</phone><name>b</name><phone>3</phone></root>`, root.String())
+}
+
+func TestElementRemoveChildShouldRemoveEveryNamedChild(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(`<root><phone>1</phone><phone>2</phone><name>a</name><phone>3</phone></root>`)
+	phones := root.ChildrenNamed("phone")
+
+	root.RemoveChild("phone")
+
+	assert.Len(t, root.Children(), 1)
+	assert.Equal(t, `<root><name>a</name></root>`, root.String())
+	assert.Nil(t, phones[0].Parent())
+}
+
+func TestElementAppendChildShouldLinkParent(t *testing.T) {
+	t.Parallel()
+
+	root := xixo.NewXMLElement()
+	root.Name = "root"
+	child := xixo.NewXMLElement()
+	child.Name = "name"
+	child.InnerText = "a"
+
+	root.AppendChild(child)
+
+	assert.Equal(t, root, child.Parent())
+	assert.Equal(t, child, root.FirstChild())
+	assert.Equal(t, `<root><name>a</name></root>`, root.String())
+}
+
+func TestElementInnerTextShouldReplaceParsedContent(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(`<root>Hello <name>world</name> !</root>`)
+
+	assert.Equal(t, `<root>Hello <name>world</name> !</root>`, root.String())
+
+	root.InnerText = "replaced"
+
+	assert.Equal(t, `<root>replaced</root>`, root.String())
+}
+
+func TestElementShouldKeepMixedContentInOrder(t *testing.T) {
+	t.Parallel()
+
+	source := `<root>Hello <b>big</b> <!-- note --><![CDATA[<raw>]]> world &amp; <i>all</i>.</root>`
+	root := createTreeFromXMLString(source)
+
+	assert.Equal(t, source, root.String())
+
+	kinds := []xixo.NodeKind{}
+	for _, node := range root.Nodes() {
+		kinds = append(kinds, node.Kind)
+	}
+
+	assert.Equal(t, []xixo.NodeKind{
+		xixo.TextNode, xixo.ElementNode, xixo.TextNode, xixo.CommentNode,
+		xixo.CDATANode, xixo.TextNode, xixo.ElementNode, xixo.TextNode,
+	}, kinds)
+	assert.Equal(t, " note ", root.Nodes()[3].Data)
+	assert.Equal(t, "<raw>", root.Nodes()[4].Data)
+	assert.Equal(t, " world & ", root.Nodes()[5].Data)
+	assert.Equal(t, ".", root.InnerText)
+
+	// changing a node rewrites only this node
+	root.Child("b").InnerText = "small"
+	root.Nodes()[5].Data = " world < "
+
+	assert.Equal(t, `<root>Hello <b>small</b> <!-- note --><![CDATA[<raw>]]> world &lt; <i>all</i>.</root>`, root.String())
+}
+
+func TestElementAppendNodeShouldBuildMixedContent(t *testing.T) {
+	t.Parallel()
+
+	child := xixo.NewXMLElement()
+	child.Name = "b"
+	child.InnerText = "x"
+
+	root := xixo.NewXMLElement()
+	root.Name = "p"
+	root.AppendNode(&xixo.Node{Kind: xixo.TextNode, Data: "a < "})
+	root.AppendNode(&xixo.Node{Kind: xixo.ElementNode, Element: child})
+	root.AppendNode(&xixo.Node{Kind: xixo.CDATANode, Data: "]]>"})
+	root.AppendNode(&xixo.Node{Kind: xixo.CommentNode, Data: "c"})
+
+	assert.Equal(t, root, child.Parent())
+	assert.Equal(t, `<p>a &lt; <b>x</b><![CDATA[]]]]><![CDATA[>]]><!--c--></p>`, root.String())
+}
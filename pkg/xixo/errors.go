@@ -0,0 +1,99 @@
+package xixo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Reasons of a ParseError, usable with errors.Is.
+var (
+	ErrUnexpectedEOF         = errors.New("unexpected end of input")
+	ErrUnterminatedAttribute = errors.New("unterminated attribute value")
+	ErrUnquotedAttribute     = errors.New("attribute value must be quoted")
+	ErrInvalidCDATA          = errors.New("invalid CDATA section marker")
+	ErrInvalidComment        = errors.New("invalid comment marker")
+	ErrMismatchedTag         = errors.New("close tag does not match the open element")
+	ErrDuplicateAttribute    = errors.New("duplicate attribute")
+	ErrAttributeWithoutValue = errors.New("attribute without value")
+	ErrInvalidName           = errors.New("invalid name")
+	ErrInvalidChar           = errors.New("illegal character")
+	ErrContentOutsideRoot    = errors.New("content outside the document element")
+	ErrInvalidReference      = errors.New("invalid entity or character reference")
+	ErrCDATAEndInText        = errors.New("CDATA section end in character data")
+	ErrMissingWhitespace     = errors.New("missing whitespace between attributes")
+)
+
+// ErrUnsupportedJSONValue is returned by a JSON callback for a value that has no XML form.
+var ErrUnsupportedJSONValue = errors.New("unsupported JSON value")
+
+// ErrInvalidMapKey is returned by a map callback for a key whose element or attribute names are not XML names.
+var ErrInvalidMapKey = errors.New("invalid map key")
+
+// ErrDropElement is returned by a callback, map and JSON callbacks included, to remove the matched element
+// from the output with the whitespace indenting it.
+var ErrDropElement = errors.New("drop element")
+
+// ParseError reports malformed input, with the position where it was detected.
+type ParseError struct {
+	// Line and Column locate the last byte read, both starting at 1. Columns count bytes.
+	Line   int
+	Column int
+	// Offset is the number of bytes read from the input, as XMLParser.TotalReadSize.
+	Offset uint64
+	// Path holds the names of the open elements, document element first.
+	Path []string
+	// Err is the reason of the error.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid xml at line %d, column %d (offset %d) in /%s: %v",
+		e.Line, e.Column, e.Offset, strings.Join(e.Path, "/"), e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// CallbackError wraps an error returned by a callback with the element that triggered it.
+type CallbackError struct {
+	// Path is the absolute path of the element, such as /root/customer.
+	Path string
+	// Line, Column and Offset locate the end of the element in the input.
+	Line   int
+	Column int
+	Offset uint64
+	// Err is the error returned by the callback.
+	Err error
+}
+
+func (e *CallbackError) Error() string {
+	return fmt.Sprintf("callback failed on %s at line %d, column %d (offset %d): %v",
+		e.Path, e.Line, e.Column, e.Offset, e.Err)
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
+
+// InterruptedError reports a stream stopped because its context is done, with the position reached.
+type InterruptedError struct {
+	// Line, Column and Offset locate the last byte read, as in ParseError.
+	Line   int
+	Column int
+	Offset uint64
+	// Path holds the names of the open elements, document element first.
+	Path []string
+	// Err is the error of the context, context.Canceled or context.DeadlineExceeded.
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("stream interrupted at line %d, column %d (offset %d) in /%s: %v",
+		e.Line, e.Column, e.Offset, strings.Join(e.Path, "/"), e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,184 @@
+package xixo
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	cdataStart    = "<![CDATA["
+	cdataEnd      = "]]>"
+	commentStart  = "<!--"
+	commentEnd    = "-->"
+	procInstStart = "<?"
+	procInstEnd   = "?>"
+)
+
+// predefinedEntities are the five entities every XML processor must recognize.
+var predefinedEntities = map[string]string{
+	"amp":  "&",
+	"lt":   "<",
+	"gt":   ">",
+	"quot": "\"",
+	"apos": "'",
+}
+
+// unescape decodes predefined and numeric character references.
+// Unknown or malformed references are kept as is.
+func unescape(s string) string {
+	if strings.IndexByte(s, '&') < 0 {
+		return s
+	}
+
+	var builder strings.Builder
+
+	builder.Grow(len(s))
+
+	for {
+		amp := strings.IndexByte(s, '&')
+		if amp < 0 {
+			builder.WriteString(s)
+
+			return builder.String()
+		}
+
+		builder.WriteString(s[:amp])
+		s = s[amp:]
+
+		semicolon := strings.IndexByte(s, ';')
+		if semicolon < 0 {
+			builder.WriteString(s)
+
+			return builder.String()
+		}
+
+		if decoded, ok := decodeReference(s[1:semicolon]); ok {
+			builder.WriteString(decoded)
+			s = s[semicolon+1:]
+		} else {
+			builder.WriteByte('&')
+			s = s[1:]
+		}
+	}
+}
+
+// decodeReference resolves the name of a reference (the part between '&' and ';').
+func decodeReference(ref string) (string, bool) {
+	if value, ok := predefinedEntities[ref]; ok {
+		return value, true
+	}
+
+	if !strings.HasPrefix(ref, "#") {
+		return "", false
+	}
+
+	var (
+		code uint64
+		err  error
+	)
+
+	if strings.HasPrefix(ref, "#x") {
+		code, err = strconv.ParseUint(ref[2:], 16, 32)
+	} else {
+		code, err = strconv.ParseUint(ref[1:], 10, 32)
+	}
+
+	if err != nil || code == 0 || !utf8.ValidRune(rune(code)) {
+		return "", false
+	}
+
+	return string(rune(code)), true
+}
+
+// textEscaper keeps carriage returns, which parsers normalize to line feeds.
+var textEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"\r", "&#13;",
+)
+
+// attribute escapers keep line feeds, carriage returns and tabs, which parsers normalize to spaces.
+var (
+	doubleQuotedAttrEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		"\"", "&quot;",
+		"\n", "&#10;",
+		"\r", "&#13;",
+		"\t", "&#9;",
+	)
+	simpleQuotedAttrEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		"'", "&apos;",
+		"\n", "&#10;",
+		"\r", "&#13;",
+		"\t", "&#9;",
+	)
+)
+
+// escapeText escapes a value to be written as character data.
+func escapeText(s string) string {
+	return escapeKeepingReferences(s, textEscaper)
+}
+
+// escapeAttr escapes a value to be written as an attribute value delimited by quote.
+func escapeAttr(s string, quote Quote) string {
+	if quote == SimpleQuote {
+		return escapeKeepingReferences(s, simpleQuotedAttrEscaper)
+	}
+
+	return escapeKeepingReferences(s, doubleQuotedAttrEscaper)
+}
+
+// escapeKeepingReferences escapes s with the escaper, except the references to entities unescape keeps as is,
+// as &nbsp; or the entities declared by a DTD, which are written back verbatim.
+func escapeKeepingReferences(s string, escaper *strings.Replacer) string {
+	start, end := unknownReference(s)
+	if start < 0 {
+		return escaper.Replace(s)
+	}
+
+	var builder strings.Builder
+
+	builder.Grow(len(s))
+
+	for start >= 0 {
+		builder.WriteString(escaper.Replace(s[:start]))
+		builder.WriteString(s[start:end])
+
+		s = s[end:]
+		start, end = unknownReference(s)
+	}
+
+	builder.WriteString(escaper.Replace(s))
+
+	return builder.String()
+}
+
+// unknownReference returns the bounds of the first reference of s to an entity other than the predefined ones,
+// or -1 when there is none.
+func unknownReference(s string) (int, int) {
+	for offset := 0; ; {
+		amp := strings.IndexByte(s[offset:], '&')
+		if amp < 0 {
+			return -1, -1
+		}
+
+		amp += offset
+
+		semicolon := strings.IndexByte(s[amp:], ';')
+		if semicolon < 0 {
+			return -1, -1
+		}
+
+		name := s[amp+1 : amp+semicolon]
+		if _, predefined := predefinedEntities[name]; !predefined && isXMLName(name) {
+			return amp, amp + semicolon + 1
+		}
+
+		offset = amp + 1
+	}
+}
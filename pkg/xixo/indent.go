@@ -0,0 +1,127 @@
+package xixo
+
+import (
+	"bufio"
+	"io"
+)
+
+// indentWriter forwards the bytes written to it, holding back the opening '<' of the last tag with the
+// whitespace indenting it, so that a matched element replaced by a callback can be taken back with its
+// indentation. Whitespace is indentation when it follows a tag, not when it ends character data.
+type indentWriter struct {
+	w    io.Writer
+	held []byte
+	// last is the last byte forwarded
+	last byte
+}
+
+func (w *indentWriter) Write(p []byte) (int, error) {
+	if len(w.held) > 0 {
+		// p may only extend the held whitespace
+		if w.held[len(w.held)-1] != '<' && indentation(p) == 0 {
+			w.held = append(w.held, p...)
+
+			return len(p), nil
+		}
+
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	split := indentation(p)
+
+	previous := w.last
+	if split > 0 {
+		previous = p[split-1]
+	}
+
+	if previous != '>' && previous != 0 {
+		// whitespace ending character data is not indentation, only the '<' is held
+		split = len(p)
+
+		if split > 0 && p[split-1] == '<' {
+			split--
+		}
+	}
+
+	if err := w.forward(p[:split]); err != nil {
+		return 0, err
+	}
+
+	w.held = append(w.held, p[split:]...)
+
+	return len(p), nil
+}
+
+// Flush forwards the held bytes.
+func (w *indentWriter) Flush() error {
+	err := w.forward(w.held)
+	w.held = w.held[:0]
+
+	return err
+}
+
+func (w *indentWriter) forward(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	w.last = p[len(p)-1]
+
+	_, err := w.w.Write(p)
+
+	return err
+}
+
+// take discards the held bytes and returns the whitespace indenting the tag taken back.
+func (w *indentWriter) take() string {
+	indent := string(w.held)
+	w.held = w.held[:0]
+
+	if len(indent) > 0 && indent[len(indent)-1] == '<' {
+		indent = indent[:len(indent)-1]
+	}
+
+	return indent
+}
+
+// indentation returns the offset of the trailing whitespace of p, followed by an optional '<'.
+func indentation(p []byte) int {
+	end := len(p)
+
+	if end > 0 && p[end-1] == '<' {
+		end--
+	}
+
+	for end > 0 && isWS(p[end-1]) {
+		end--
+	}
+
+	return end
+}
+
+// replaceElement writes the rendered nodes in place of the element whose '<' is held by indent,
+// each with the indentation of the element, which is written alone when there is no node and keepIndent
+// is set. out must have been flushed to indent.
+func replaceElement(out *bufio.Writer, indent *indentWriter, nodes [][]byte, keepIndent bool) error {
+	whitespace := indent.take()
+
+	if len(nodes) == 0 && keepIndent {
+		_, err := out.WriteString(whitespace)
+
+		return err
+	}
+
+	for _, data := range nodes {
+		if _, err := out.WriteString(whitespace); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
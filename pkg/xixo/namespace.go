@@ -0,0 +1,114 @@
+package xixo
+
+import "strings"
+
+const (
+	// XMLNamespaceURI is the namespace bound to the reserved xml prefix.
+	XMLNamespaceURI = "http://www.w3.org/XML/1998/namespace"
+	// XMLNSNamespaceURI is the namespace of the namespace declaration attributes.
+	XMLNSNamespaceURI = "http://www.w3.org/2000/xmlns/"
+)
+
+// splitName splits a qualified name into its prefix and local name.
+func splitName(name string) (string, string) {
+	if prefix, local, found := strings.Cut(name, ":"); found {
+		return prefix, local
+	}
+
+	return "", name
+}
+
+// expandedName returns the {uri}local notation of a name, or the local name alone when uri is empty.
+func expandedName(uri, local string) string {
+	if uri == "" {
+		return local
+	}
+
+	return "{" + uri + "}" + local
+}
+
+// namespaceStack holds the namespace declarations in scope while streaming,
+// with one frame per open element (nil when the element declares nothing).
+type namespaceStack struct {
+	frames []map[string]string
+}
+
+// push opens the scope of element: its declarations are recorded, then the
+// namespace URIs of the element and its attributes are resolved.
+func (s *namespaceStack) push(element *XMLElement) {
+	var frame map[string]string
+
+	for _, key := range element.AttrKeys {
+		prefix, local := splitName(key)
+
+		switch {
+		case prefix == "" && local == "xmlns":
+			if frame == nil {
+				frame = map[string]string{}
+			}
+
+			frame[""] = element.Attrs[key].Value
+		case prefix == "xmlns":
+			if frame == nil {
+				frame = map[string]string{}
+			}
+
+			frame[local] = element.Attrs[key].Value
+		}
+	}
+
+	s.frames = append(s.frames, frame)
+
+	prefix, _ := splitName(element.Name)
+	element.namespaceURI = s.lookup(prefix)
+
+	for _, key := range element.AttrKeys {
+		if uri := s.attributeURI(key); uri != "" {
+			if element.attrURIs == nil {
+				element.attrURIs = map[string]string{}
+			}
+
+			element.attrURIs[key] = uri
+		}
+	}
+}
+
+// pop closes the scope of the innermost open element.
+func (s *namespaceStack) pop() {
+	if len(s.frames) > 0 {
+		s.frames = s.frames[:len(s.frames)-1]
+	}
+}
+
+// lookup returns the namespace URI bound to prefix, the empty prefix standing for the default namespace.
+func (s *namespaceStack) lookup(prefix string) string {
+	switch prefix {
+	case "xml":
+		return XMLNamespaceURI
+	case "xmlns":
+		return XMLNSNamespaceURI
+	}
+
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		if uri, ok := s.frames[i][prefix]; ok {
+			return uri
+		}
+	}
+
+	return ""
+}
+
+// attributeURI resolves the namespace URI of an attribute, unprefixed attributes having no namespace.
+func (s *namespaceStack) attributeURI(name string) string {
+	prefix, _ := splitName(name)
+
+	if prefix == "" {
+		if name == "xmlns" {
+			return XMLNSNamespaceURI
+		}
+
+		return ""
+	}
+
+	return s.lookup(prefix)
+}
@@ -0,0 +1,113 @@
+package xixo
+
+import "strings"
+
+// NodeKind is the kind of a node in the content of an element.
+type NodeKind int
+
+const (
+	// ElementNode is a child element.
+	ElementNode NodeKind = iota
+	// TextNode is character data, references being decoded.
+	TextNode
+	// CommentNode is a comment.
+	CommentNode
+	// CDATANode is a CDATA section.
+	CDATANode
+	// ProcInstNode is a processing instruction, its Data being the target and the instruction.
+	ProcInstNode
+)
+
+// Node is an item of the content of an element: a child element, character data,
+// a comment, a CDATA section or a processing instruction, in document order.
+type Node struct {
+	Kind NodeKind
+	// Data is the decoded character data of a text node, or the content of a comment, CDATA section
+	// or processing instruction.
+	Data string
+	// Element is the child of an element node.
+	Element *XMLElement
+
+	// raw is the character data as read, written back as long as Data is unchanged
+	raw string
+}
+
+// encode writes the node.
+func (node *Node) encode(enc *encoder) {
+	switch node.Kind {
+	case ElementNode:
+		if node.Element != nil {
+			node.Element.encode(enc, true)
+		}
+	case TextNode:
+		if node.raw != "" && unescape(node.raw) == node.Data {
+			enc.writeString(node.raw)
+		} else {
+			enc.writeString(escapeText(node.Data))
+		}
+	case CommentNode:
+		enc.writeString(commentStart)
+		enc.writeString(node.Data)
+		enc.writeString(commentEnd)
+	case CDATANode:
+		// a terminator in the data is split over two sections
+		enc.writeString(cdataStart)
+		enc.writeString(strings.ReplaceAll(node.Data, cdataEnd, "]]"+cdataEnd+cdataStart+">"))
+		enc.writeString(cdataEnd)
+	case ProcInstNode:
+		enc.writeString(procInstStart)
+		enc.writeString(node.Data)
+		enc.writeString(procInstEnd)
+	}
+}
+
+// isBlank reports whether the node is whitespace, as the indentation of an element.
+func (node *Node) isBlank() bool {
+	return node.Kind == TextNode && strings.TrimSpace(node.Data) == ""
+}
+
+// trailingText returns the character data following the last element node, the InnerText of a parsed element.
+func trailingText(nodes []*Node) string {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i].Kind == ElementNode {
+			return nodesText(nodes[i+1:])
+		}
+	}
+
+	return nodesText(nodes)
+}
+
+// nodesText returns the concatenation of the character data of text nodes and CDATA sections.
+func nodesText(nodes []*Node) string {
+	var builder strings.Builder
+
+	for _, node := range nodes {
+		if node.Kind == TextNode || node.Kind == CDATANode {
+			builder.WriteString(node.Data)
+		}
+	}
+
+	return builder.String()
+}
+
+// singleElement returns the element of nodes made of a single element node, or nil.
+func singleElement(nodes []*Node) *XMLElement {
+	if len(nodes) != 1 || nodes[0].Kind != ElementNode {
+		return nil
+	}
+
+	return nodes[0].Element
+}
+
+// nodeElements returns the elements of the element nodes.
+func nodeElements(nodes []*Node) []*XMLElement {
+	var elements []*XMLElement
+
+	for _, node := range nodes {
+		if node.Kind == ElementNode && node.Element != nil {
+			elements = append(elements, node.Element)
+		}
+	}
+
+	return elements
+}
@@ -0,0 +1,93 @@
+package xixo
+
+import (
+	"iter"
+	"maps"
+	"slices"
+)
+
+// OrderedMap is a map of strings keeping its keys in the order they were first set.
+type OrderedMap struct {
+	keys   []string
+	values map[string]string
+}
+
+// NewOrderedMap returns an empty OrderedMap.
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{keys: []string{}, values: map[string]string{}}
+}
+
+// Get returns the value of key and whether it is set.
+func (m *OrderedMap) Get(key string) (string, bool) {
+	value, ok := m.values[key]
+
+	return value, ok
+}
+
+// Set sets the value of key, a new key coming after the others.
+func (m *OrderedMap) Set(key string, value string) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+
+	m.values[key] = value
+}
+
+// Delete removes key.
+func (m *OrderedMap) Delete(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+
+	delete(m.values, key)
+	m.keys = slices.DeleteFunc(m.keys, func(k string) bool { return k == key })
+}
+
+// Len returns the number of keys.
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys in order.
+func (m *OrderedMap) Keys() []string {
+	return slices.Clone(m.keys)
+}
+
+// All returns an iterator over the keys and values in order.
+func (m *OrderedMap) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, key := range m.keys {
+			if !yield(key, m.values[key]) {
+				return
+			}
+		}
+	}
+}
+
+// Map returns the keys and values in a map.
+func (m *OrderedMap) Map() map[string]string {
+	return maps.Clone(m.values)
+}
+
+// clone returns a copy of the map.
+func (m *OrderedMap) clone() *OrderedMap {
+	return &OrderedMap{keys: slices.Clone(m.keys), values: maps.Clone(m.values)}
+}
+
+// orderedResult returns the map returned by a callback given original: the keys of original keep
+// their order, new keys follow in sorted order.
+func orderedResult(original *OrderedMap, result map[string]string) *OrderedMap {
+	ordered := NewOrderedMap()
+
+	for _, key := range original.keys {
+		if value, ok := result[key]; ok {
+			ordered.Set(key, value)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(result)) {
+		ordered.Set(key, result[key])
+	}
+
+	return ordered
+}
@@ -2,75 +2,311 @@
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"io"
+	"iter"
 	"strings"
 
 	"github.com/rs/zerolog/log"
 )
 
+// loopElement is a callback registered on a path expression, optionally guarded by a predicate.
+type loopElement struct {
+	match     string
+	pattern   pathPattern
+	predicate Predicate
+	callback  CallbackNodesContext
+}
+
 type XMLParser struct {
 	reader            *bufio.Reader
 	writer            *bufio.Writer
-	loopElements      map[string]Callback
-	resultChannel     chan *XMLElement
+	indent            *indentWriter
+	loopElements      []loopElement
 	skipElements      map[string]bool
 	attrOnlyElements  map[string]bool
 	skipOuterElements bool
-	xpathEnabled      bool
 	scratch           *scratch
 	scratchInnerText  *scratch
 	scratchWriter     *scratch
+	namespaces        namespaceStack
+	path              []*XMLElement
+	callbackCount     uint64
 	deffer            bool
 	TotalReadSize     uint64
 	nextWrite         *byte
+	text              textChecker
+	line              int
+	column            int
+	lastColumn        int
+	lastByte          byte
+	strict            bool
+	cdataAsText       bool
+	rootSeen          bool
+	decoder           charDecoder
+	prevDecoder       charDecoder
+	started           bool
+	pending           []*XMLElement
+	end               error
+	output            *orderedOutput
 }
 
+// NewXMLParser returns a parser copying reader to writer, with the registered callbacks applied.
+// The writer may be nil when elements are only consumed with Next or Elements.
 func NewXMLParser(reader io.Reader, writer io.Writer) *XMLParser {
+	if writer == nil {
+		writer = io.Discard
+	}
+
+	indent := &indentWriter{w: writer}
+
 	return &XMLParser{
-		reader: bufio.NewReader(reader), writer: bufio.NewWriter(writer),
-		loopElements:     map[string]Callback{},
+		reader: bufio.NewReader(reader), writer: bufio.NewWriter(indent), indent: indent,
+		loopElements:     []loopElement{},
 		attrOnlyElements: map[string]bool{},
-		resultChannel:    make(chan *XMLElement, 256),
 		skipElements:     map[string]bool{},
 		scratch:          &scratch{data: make([]byte, 1024)},
 		scratchInnerText: &scratch{data: make([]byte, 1024)},
 		scratchWriter:    &scratch{data: make([]byte, 1024)},
+		line:             1,
 	}
 }
 
+// Stream parses the whole input, applying the callbacks and writing the result.
 func (x *XMLParser) Stream() error {
-	defer func() {
-		// write pending byte
-		if x.nextWrite != nil {
-			err := x.writer.WriteByte(*x.nextWrite)
-			if err != nil {
-				log.Error().Err(err).Msg("error when closing file")
+	return x.StreamContext(context.Background())
+}
+
+// StreamContext is Stream with a context checked between elements and passed to the callbacks.
+// Once the context is done, the output read so far is flushed and an InterruptedError wrapping
+// ctx.Err() is returned.
+func (x *XMLParser) StreamContext(ctx context.Context) error {
+	for {
+		_, err := x.NextContext(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Next parses the input up to the next element selected by a registered path expression and
+// returns it, once its callback ran. Elements nested in a selected element are returned before it.
+// The output is written as the input is consumed. At the end of the input Next returns io.EOF,
+// after any error the same error is returned again.
+func (x *XMLParser) Next() (*XMLElement, error) {
+	return x.NextContext(context.Background())
+}
+
+// NextContext is Next with a context checked between elements and passed to the callbacks.
+func (x *XMLParser) NextContext(ctx context.Context) (*XMLElement, error) {
+	done := ctx.Done()
+
+	for len(x.pending) == 0 {
+		if x.end != nil {
+			return nil, x.end
+		}
+
+		var err error
+
+		select {
+		case <-done:
+			err = x.interruptedError(ctx.Err())
+		default:
+			err = x.parse(ctx)
+		}
+
+		if err == nil && x.output != nil {
+			err = x.output.flush(false)
+		}
+
+		if err != nil {
+			x.finish(err)
+		}
+	}
+
+	element := x.pending[0]
+	x.pending[0] = nil
+	x.pending = x.pending[1:]
+
+	return element, nil
+}
+
+// Elements returns an iterator over the elements returned by Next. The iteration stops at the end
+// of the input, or after yielding the first error with a nil element.
+func (x *XMLParser) Elements() iter.Seq2[*XMLElement, error] {
+	return func(yield func(*XMLElement, error) bool) {
+		for {
+			element, err := x.Next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if !yield(element, err) || err != nil {
+				return
 			}
 		}
+	}
+}
 
-		x.writer.Flush()
-	}()
+// finish ends the parsing with err, io.EOF standing for a complete input, and flushes the output.
+func (x *XMLParser) finish(err error) {
+	if errors.Is(err, io.EOF) {
+		err = io.EOF
+	}
 
-	err := x.parse()
-	if err != nil && !errors.Is(err, io.EOF) {
-		return err
+	// write pending byte
+	if x.nextWrite != nil {
+		err := x.writer.WriteByte(*x.nextWrite)
+		if err != nil {
+			log.Error().Err(err).Msg("error when closing file")
+		}
+
+		x.nextWrite = nil
 	}
 
-	return nil
+	x.writer.Flush()
+
+	if x.output != nil {
+		if err == io.EOF {
+			if flushErr := x.output.flush(true); flushErr != nil {
+				err = flushErr
+			}
+		} else {
+			// the elements already transformed are written before the others are canceled
+			_ = x.output.flush(false)
+		}
+
+		x.output.abort()
+		x.output.out.Flush()
+	}
+
+	x.indent.Flush()
+
+	x.end = err
 }
 
+// yield queues an element to be returned by Next.
+func (x *XMLParser) yield(element *XMLElement) {
+	if x.output != nil {
+		x.output.yield(element)
+
+		return
+	}
+
+	x.pending = append(x.pending, element)
+}
+
+// queue appends an element to those returned by Next.
+func (x *XMLParser) queue(element *XMLElement) {
+	x.pending = append(x.pending, element)
+}
+
+// RegisterOption configures a registration, see Register.
+type RegisterOption func(*registration)
+
+type registration struct {
+	predicate Predicate
+}
+
+// WithPredicate guards a registration by a predicate. The predicate is evaluated once the element
+// tree is built: rejected elements fall back to the next registered expression selecting them, or
+// are written unchanged.
+func WithPredicate(predicate Predicate) RegisterOption {
+	return func(r *registration) {
+		r.predicate = predicate
+	}
+}
+
+// Register registers a context-aware callback on the elements selected by the path expression match:
+//
+//	address                 any address element
+//	customer/address        address elements whose parent is a customer
+//	/root/customer/address  absolute path from the document element
+//	//address               address elements at any depth, same as address
+//	/root//address          address elements at any depth below root
+//
+// Each step is either the qualified name as written in the document (prefix:local),
+// the {uri}local expanded name, matching whatever prefix the producer bound to the
+// namespace, or the * wildcard. When several expressions select an element, the first
+// registered wins; registering the same expression again without a predicate replaces
+// its callback.
+//
+// Every kind of callback is registered through its adapter, e.g.
+// Register(match, XMLElementToMapCallbackContext(cb), WithPredicate(p)), and callbacks
+// ignoring the context through IgnoreContext.
+func (x *XMLParser) Register(match string, callback CallbackContext, options ...RegisterOption) {
+	x.RegisterNodes(match, elementNodes(callback), options...)
+}
+
+// RegisterNodes registers a callback returning the nodes written in place of the elements
+// selected by the path expression match, see CallbackNodesContext and Register. Nodes are
+// returned by Next as elements, other nodes being only written.
+func (x *XMLParser) RegisterNodes(match string, callback CallbackNodesContext, options ...RegisterOption) {
+	var r registration
+
+	for _, option := range options {
+		option(&r)
+	}
+
+	if r.predicate == nil {
+		for i, loop := range x.loopElements {
+			if loop.match == match && loop.predicate == nil {
+				x.loopElements[i].callback = callback
+
+				return
+			}
+		}
+	}
+
+	x.loopElements = append(x.loopElements, loopElement{
+		match:     match,
+		pattern:   compilePath(match),
+		predicate: r.predicate,
+		callback:  callback,
+	})
+}
+
+// RegisterMatch selects the elements of the path expression match to be returned by Next,
+// without transforming them. See Register for the syntax of match.
+func (x *XMLParser) RegisterMatch(match string, options ...RegisterOption) {
+	x.Register(match, nil, options...)
+}
+
+// RegisterCallback registers a callback on the elements selected by the path expression match,
+// see Register.
 func (x *XMLParser) RegisterCallback(match string, callback Callback) {
-	x.loopElements[match] = callback
+	x.Register(match, IgnoreContext(callback))
+}
+
+// RegisterNodesCallback registers a nodes callback, see RegisterNodes.
+func (x *XMLParser) RegisterNodesCallback(match string, callback CallbackNodes) {
+	x.RegisterNodes(match, IgnoreNodesContext(callback))
 }
 
 func (x *XMLParser) RegisterJSONCallback(match string, callback CallbackJSON) {
-	x.loopElements[match] = XMLElementToJSONCallback(callback)
+	x.RegisterCallback(match, XMLElementToJSONCallback(callback))
+}
+
+// RegisterJSONTreeCallback registers a callback receiving the whole element tree as JSON in the given
+// convention, see XMLElementToJSONTreeCallback.
+func (x *XMLParser) RegisterJSONTreeCallback(match string, convention JSONConvention, callback CallbackJSON) {
+	x.RegisterCallback(match, XMLElementToJSONTreeCallback(convention, callback))
+}
+
+// RegisterOrderedMapCallback registers a map callback receiving and returning an ordered map,
+// see XMLElementToOrderedMapCallback.
+func (x *XMLParser) RegisterOrderedMapCallback(match string, callback CallbackOrderedMap) {
+	x.RegisterCallback(match, XMLElementToOrderedMapCallback(callback))
 }
 
 func (x *XMLParser) RegisterMapCallback(match string, callback CallbackMap) {
-	x.loopElements[match] = XMLElementToMapCallback(callback)
+	x.RegisterCallback(match, XMLElementToMapCallback(callback))
 }
 
 func (x *XMLParser) SkipElements(skipElements []string) *XMLParser {
@@ -99,15 +335,47 @@ func (x *XMLParser) SkipOuterElements() *XMLParser {
 	return x
 }
 
+// EnableXpath is kept for compatibility: element trees always hold the parent and ordered children
+// links needed to navigate them with FirstChild, NextSibling and XPath expressions.
+//
+// Deprecated: element trees are always navigable.
 func (x *XMLParser) EnableXpath() *XMLParser {
-	x.xpathEnabled = true
+	return x
+}
+
+// Concurrency runs the callbacks of the loop elements on a pool of workers. The output keeps the
+// document order: at most twice as many elements as workers are pending, and the parser waits for
+// them when the bytes read after them exceed 1 MiB. The first error of a callback cancels the context
+// passed to the others and stops the stream. Callbacks must be safe for concurrent use, callbacks
+// of nested loop elements still run sequentially before their ancestor is dispatched.
+func (x *XMLParser) Concurrency(workers int) *XMLParser {
+	if workers > 1 && x.output == nil {
+		x.output = newOrderedOutput(x.writer, x.indent, workers, x.queue)
+		x.writer = bufio.NewWriter(x.output)
+	}
 
 	return x
 }
 
-func (x *XMLParser) parse() error {
-	defer close(x.resultChannel)
+// Strict enables the validation of well-formedness as defined by XML 1.0: balanced tags,
+// quoted and unique attributes, name syntax, legal characters and a single document element.
+// The stream fails on the first violation with a ParseError. By default the parser is lenient.
+func (x *XMLParser) Strict() *XMLParser {
+	x.strict = true
+
+	return x
+}
 
+// CDATAAsText reads CDATA sections in matched elements as plain text: they are written back escaped
+// instead of being wrapped in CDATA sections. Unmatched regions of the document are copied unchanged.
+func (x *XMLParser) CDATAAsText() *XMLParser {
+	x.cdataAsText = true
+
+	return x
+}
+
+// parse consumes the next token of the input, queuing the elements selected by path expressions.
+func (x *XMLParser) parse(ctx context.Context) error {
 	var element *XMLElement
 
 	var tagClosed bool
@@ -118,107 +386,441 @@ func (x *XMLParser) parse() error {
 
 	var iscomment bool
 
-	err = x.skipDeclerations()
+	if !x.started {
+		x.started = true
+
+		err = x.skipDeclerations()
+
+		if errors.Is(err, io.EOF) {
+			if err := x.checkEndOfDocument(); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// character data of the document element is copied in chunks
+	if len(x.path) > 0 {
+		err = x.copyText()
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err = x.readByte()
+
+	if errors.Is(err, io.EOF) {
+		if err := x.checkEndOfDocument(); err != nil {
+			return err
+		}
+	}
 
 	if err != nil {
 		return err
 	}
 
-	for {
-		b, err = x.readByte()
+	if isWS(b) {
+		return nil
+	}
 
+	if b != '<' && len(x.path) == 0 {
+		err = x.checkOutsideRoot(b)
 		if err != nil {
 			return err
 		}
+	}
 
-		if x.isWS(b) {
-			continue
+	if b == '<' {
+		iscdata, _, err := x.isCDATA()
+		if err != nil {
+			return err
+		}
+
+		if iscdata {
+			return nil
+		}
+
+		iscomment, err = x.readComment()
+
+		if err != nil {
+			return err
+		}
+
+		if iscomment {
+			return nil
+		}
+
+		isinstruction, err := x.readProcInst()
+		if err != nil || isinstruction {
+			return err
+		}
+
+		x.defferWrite()
+
+		element, tagClosed, err = x.startElement()
+
+		if err != nil {
+			return err
 		}
 
-		if b == '<' {
-			iscdata, _, err := x.isCDATA()
+		if x.isCloseTag(element) {
+			err = x.checkCloseTag(element.Name[1:])
 			if err != nil {
 				return err
 			}
 
-			if iscdata {
-				continue
+			x.popElement()
+
+			return x.commitDefferWrite()
+		}
+
+		if len(x.path) == 0 {
+			if x.strict && x.rootSeen {
+				return x.parseError(ErrContentOutsideRoot)
 			}
 
-			iscomment, err = x.readComment()
+			x.rootSeen = true
+		}
 
-			if err != nil {
-				return err
+		x.pushElement(element)
+		candidates := x.lookupCallbacks()
+
+		if tagClosed {
+			x.popElement()
+		}
+
+		if len(candidates) > 0 {
+			// the opening '<' precedes the deferred bytes
+			element.setStartTag("<" + string(x.scratchWriter.bytes()))
+
+			if tagClosed {
+				x.scratchInnerText.reset()
+
+				return x.applySelfClosingCallback(ctx, candidates, element)
 			}
 
-			if iscomment {
-				continue
+			callbackCount := x.callbackCount
+
+			if _, ok := x.attrOnlyElements[element.Name]; !ok {
+				element = x.getElementTree(ctx, element)
 			}
 
-			x.defferWrite()
+			if element.Err != nil {
+				return element.Err
+			}
 
-			element, tagClosed, err = x.startElement()
+			callback, ok := x.selectCallback(candidates, element)
+			if !ok {
+				// rejected by predicates, the element is written as it was read
+				// unless callbacks on nested elements changed it
+				return x.writeUnselectedElement(element, callbackCount)
+			}
+
+			if callback == nil {
+				// selected for Next only
+				x.yield(element)
+
+				return x.writeUnselectedElement(element, callbackCount)
+			}
+
+			if x.output != nil {
+				return x.dispatchCallback(ctx, callback, element, renderNodes)
+			}
+
+			nodes, err := x.applyCallback(ctx, callback, element)
+			if err != nil {
+				return err
+			}
+
+			x.yieldNodes(nodes)
 
+			err = x.writeNodes(nodes)
+			if err != nil {
+				return err
+			}
+		} else if _, ok := x.skipElements[element.Name]; ok && x.skipOuterElements && !tagClosed {
+			err = x.skipElement(element.Name)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = x.commitDefferWrite()
 			if err != nil {
 				return err
 			}
 
-			if _, found := x.loopElements[element.Name]; found {
-				if tagClosed {
-					err = x.commitDefferWrite()
-					if err != nil {
-						return err
-					}
+			if !tagClosed && x.canCopySubtree() {
+				return x.copySubtree()
+			}
+		}
+	}
 
-					x.scratchInnerText.reset()
+	return nil
+}
 
-					continue
-				}
+// applyCallback runs the callback on a matched element, once it has been closed.
+func (x *XMLParser) applyCallback(
+	ctx context.Context, callback CallbackNodesContext, element *XMLElement,
+) ([]*Node, error) {
+	err := x.beforeCallback(ctx, element)
+	if err != nil {
+		return nil, err
+	}
 
-				if _, ok := x.attrOnlyElements[element.Name]; !ok {
-					element = x.getElementTree(element)
-				}
-				x.resultChannel <- element
+	nodes, err := callback(ctx, element)
+	if err != nil {
+		return nil, x.callbackError(element, err)
+	}
 
-				if callback, ok := x.loopElements[element.Name]; ok {
-					element.outerTextBefore = ""
+	return nodes, nil
+}
 
-					mutatedElement, err := callback(element)
-					if err != nil {
-						return err
-					}
+// dispatchCallback runs the callback of a matched element on a worker, render giving the output
+// written in place of the deferred bytes.
+func (x *XMLParser) dispatchCallback(
+	ctx context.Context, callback CallbackNodesContext, element *XMLElement, render func([]*Node) rendering,
+) error {
+	err := x.beforeCallback(ctx, element)
+	if err != nil {
+		return err
+	}
 
-					_, err = x.writer.WriteString(mutatedElement.String()[1:])
-					if err != nil {
-						return err
-					}
+	// the failure is located while the parser is still at the end of the element
+	failure := x.callbackError(element, nil)
 
-					x.cancelDefferWrite()
-				}
+	// the bytes before the element must reach the output before it
+	err = x.writer.Flush()
+	if err != nil {
+		return err
+	}
 
-				if element.Err != nil {
-					return element.Err
-				}
-			} else if x.skipOuterElements {
-				if _, ok := x.skipElements[element.Name]; ok && !tagClosed {
-					err = x.skipElement(element.Name)
-					if err != nil {
-						return err
-					}
-
-					continue
-				}
-			} else {
-				err = x.commitDefferWrite()
-				if err != nil {
-					return err
-				}
-			}
+	x.cancelDefferWrite()
+
+	keepIndent := !x.endsLine()
+
+	return x.output.dispatch(ctx, func(ctx context.Context) (rendering, error) {
+		var nodes []*Node
+
+		// another callback may have failed while waiting for a worker
+		err := ctx.Err()
+		if err == nil {
+			nodes, err = callback(ctx, element)
 		}
+
+		if err != nil {
+			failure.Err = err
+
+			return rendering{}, failure
+		}
+
+		output := render(nodes)
+		output.keepIndent = keepIndent
+
+		return output, nil
+	})
+}
+
+// beforeCallback checks the context and prepares a matched element for its callback.
+func (x *XMLParser) beforeCallback(ctx context.Context, element *XMLElement) error {
+	if err := ctx.Err(); err != nil {
+		return x.interruptedError(err)
+	}
+
+	x.callbackCount++
+
+	return nil
+}
+
+// callbackError wraps the error of the callback run on element.
+func (x *XMLParser) callbackError(element *XMLElement, err error) *CallbackError {
+	return &CallbackError{
+		Path:   "/" + strings.Join(append(x.openPath(), element.Name), "/"),
+		Line:   x.line,
+		Column: x.column,
+		Offset: x.TotalReadSize,
+		Err:    err,
 	}
 }
 
-func (x *XMLParser) getElementTree(result *XMLElement) *XMLElement {
+// applyNestedCallback runs the callback selected for an element matched inside another loop element,
+// returning the nodes taking its place.
+func (x *XMLParser) applyNestedCallback(
+	ctx context.Context, candidates []loopElement, element *XMLElement,
+) ([]*Node, error) {
+	callback, ok := x.selectCallback(candidates, element)
+	if !ok {
+		return []*Node{{Kind: ElementNode, Element: element}}, nil
+	}
+
+	if callback == nil {
+		x.yield(element)
+
+		return []*Node{{Kind: ElementNode, Element: element}}, nil
+	}
+
+	nodes, err := x.applyCallback(ctx, callback, element)
+	if err != nil {
+		return nil, err
+	}
+
+	x.yieldNodes(nodes)
+
+	return nodes, nil
+}
+
+// yieldNodes queues the elements returned by a callback to be returned by Next.
+func (x *XMLParser) yieldNodes(nodes []*Node) {
+	for _, element := range nodeElements(nodes) {
+		x.yield(element)
+	}
+}
+
+// writeUnselectedElement writes a loop element rejected by predicates: its original bytes are kept
+// unless callbacks ran on nested elements since callbackCount was recorded.
+func (x *XMLParser) writeUnselectedElement(element *XMLElement, callbackCount uint64) error {
+	if x.callbackCount == callbackCount {
+		return x.commitDefferWrite()
+	}
+
+	return x.writeElement(element)
+}
+
+// writeElement writes a transformed element in place of the deferred bytes of the matched one.
+func (x *XMLParser) writeElement(element *XMLElement) error {
+	// the opening '<' has already been written
+	enc := encoder{w: x.writer}
+
+	element.encode(&enc, false)
+
+	if enc.err != nil {
+		return enc.err
+	}
+
+	x.cancelDefferWrite()
+
+	return nil
+}
+
+// renderElement returns the bytes written by writeElement, for a worker.
+func renderElement(element *XMLElement) []byte {
+	var buffer bytes.Buffer
+
+	element.encode(&encoder{w: &buffer}, false)
+
+	return buffer.Bytes()
+}
+
+// writeNodes writes the nodes returned by a callback in place of the matched element. A single element
+// takes the place of the deferred bytes, other nodes replace the element with its indentation.
+func (x *XMLParser) writeNodes(nodes []*Node) error {
+	if element := singleElement(nodes); element != nil {
+		return x.writeElement(element)
+	}
+
+	x.cancelDefferWrite()
+
+	// the '<' of the element is held by the indent writer once flushed
+	err := x.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return replaceElement(x.writer, x.indent, renderNodes(nodes).nodes, !x.endsLine())
+}
+
+// endsLine reports whether the element just read ends its line: it is followed by a line break or by
+// the end tag of its parent, possibly after spaces. Its indentation is not shared with a next sibling.
+func (x *XMLParser) endsLine() bool {
+	for n := 1; ; n++ {
+		next, err := x.reader.Peek(n)
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+
+		if err != nil {
+			return false
+		}
+
+		switch next[n-1] {
+		case ' ', '\t':
+			continue
+		case '\n', '\r':
+			return true
+		case '<':
+			next, err = x.reader.Peek(n + 1)
+
+			return err == nil && next[n] == '/'
+		default:
+			return false
+		}
+	}
+}
+
+// renderNodes returns the output of writeNodes, for a worker.
+func renderNodes(nodes []*Node) rendering {
+	if element := singleElement(nodes); element != nil {
+		return rendering{elements: []*XMLElement{element}, data: renderElement(element)}
+	}
+
+	result := rendering{elements: nodeElements(nodes), replace: true}
+
+	for _, node := range nodes {
+		var buffer bytes.Buffer
+
+		node.encode(&encoder{w: &buffer})
+
+		result.nodes = append(result.nodes, buffer.Bytes())
+	}
+
+	return result
+}
+
+// applySelfClosingCallback runs the callback on a matched self-closing element. The element keeps
+// its original bytes unless the callback changes it, and its self-closing form unless content is added.
+func (x *XMLParser) applySelfClosingCallback(ctx context.Context, candidates []loopElement, element *XMLElement) error {
+	callback, ok := x.selectCallback(candidates, element)
+	if !ok {
+		return x.commitDefferWrite()
+	}
+
+	if callback == nil {
+		x.yield(element)
+
+		return x.commitDefferWrite()
+	}
+
+	original := element.String()
+
+	if x.output != nil {
+		raw := bytes.Clone(x.scratchWriter.bytes())
+
+		return x.dispatchCallback(ctx, callback, element, func(nodes []*Node) rendering {
+			if mutatedElement := singleElement(nodes); mutatedElement != nil && mutatedElement.String() == original {
+				return rendering{elements: []*XMLElement{mutatedElement}, data: raw}
+			}
+
+			return renderNodes(nodes)
+		})
+	}
+
+	nodes, err := x.applyCallback(ctx, callback, element)
+	if err != nil {
+		return err
+	}
+
+	x.yieldNodes(nodes)
+
+	if mutatedElement := singleElement(nodes); mutatedElement != nil && mutatedElement.String() == original {
+		return x.commitDefferWrite()
+	}
+
+	return x.writeNodes(nodes)
+}
+
+func (x *XMLParser) getElementTree(ctx context.Context, result *XMLElement) *XMLElement {
 	if result.Err != nil {
 		return result
 	}
@@ -232,19 +834,28 @@ func (x *XMLParser) getElementTree(result *XMLElement) *XMLElement {
 		iscomment bool
 	)
 
-	result.outerTextBefore = string(x.scratchInnerText.bytes())
-	x.scratchInnerText.reset() // this hold the inner text
+	x.scratchInnerText.reset() // this hold the text until the next node
 
 	for {
 		cur, err = x.readByte()
 
 		if err != nil {
-			result.Err = err
+			result.Err = x.eofError(err, ErrUnexpectedEOF)
 
 			return result
 		}
 
 		if cur == '<' {
+			if err = x.checkTextEnd(); err != nil {
+				result.Err = err
+
+				return result
+			}
+
+			x.flushText(result)
+
+			tagOffset := len(x.scratchWriter.bytes()) - 1
+
 			iscdata, cddata, err := x.isCDATA()
 			if err != nil {
 				result.Err = err
@@ -252,10 +863,14 @@ func (x *XMLParser) getElementTree(result *XMLElement) *XMLElement {
 				return result
 			}
 
+			if iscdata && x.cdataAsText {
+				result.AppendNode(&Node{Kind: TextNode, Data: string(cddata)})
+
+				continue
+			}
+
 			if iscdata {
-				for _, cd := range cddata {
-					x.scratchInnerText.add(cd)
-				}
+				result.AppendNode(&Node{Kind: CDATANode, Data: string(cddata)})
 
 				continue
 			}
@@ -269,121 +884,378 @@ func (x *XMLParser) getElementTree(result *XMLElement) *XMLElement {
 			}
 
 			if iscomment {
+				result.AppendNode(&Node{Kind: CommentNode, Data: string(x.scratch.bytes())})
+
+				continue
+			}
+
+			isinstruction, err := x.readProcInst()
+			if err != nil {
+				result.Err = err
+
+				return result
+			}
+
+			if isinstruction {
+				result.AppendNode(&Node{Kind: ProcInstNode, Data: string(x.scratch.bytes())})
+
 				continue
 			}
 
 			next, err = x.readByte()
 
 			if err != nil {
+				result.Err = x.eofError(err, ErrUnexpectedEOF)
+
+				return result
+			}
+
+			if next == '/' { // close tag
+				tag, err := x.closeTagName()
+				if err != nil {
+					result.Err = err
+
+					return result
+				}
+
+				if tag == result.Name {
+					x.popElement()
+					result.setEndTag(x.rawSince(tagOffset))
+
+					result.InnerText = trailingText(result.nodes)
+					result.contentParsed = true
+
+					return result
+				}
+
+				// a close tag not matching the element is ignored, unless strict
+				result.Err = x.checkCloseTag(tag)
+				if result.Err != nil {
+					return result
+				}
+
+				continue
+			} else {
+				err = x.unreadByte()
+				if err != nil {
+					result.Err = err
+
+					return result
+				}
+			}
+
+			element, tagClosed, err = x.startElement()
+
+			if err != nil {
+				result.Err = err
+
+				return result
+			}
+
+			x.pushElement(element)
+			element.setStartTag(x.rawSince(tagOffset))
+			candidates := x.lookupCallbacks()
+
+			if tagClosed {
+				x.popElement()
+			}
+
+			if _, ok := x.skipElements[element.Name]; ok && !tagClosed {
+				err = x.skipElement(element.Name)
+				if err != nil {
+					result.Err = err
+
+					return result
+				}
+
+				continue
+			}
+
+			if !tagClosed {
+				element = x.getElementTree(ctx, element)
+			}
+
+			// an error in a descendant stops the whole tree
+			if element.Err != nil {
+				result.Err = element.Err
+
+				return result
+			}
+
+			// nested loop elements are transformed before their ancestors see them
+			if len(candidates) > 0 {
+				nodes, err := x.applyNestedCallback(ctx, candidates, element)
+				if err != nil {
+					result.Err = err
+
+					return result
+				}
+
+				result.appendIndented(nodes, !x.endsLine())
+
+				continue
+			}
+
+			result.AppendChild(element)
+		} else {
+			if err = x.checkText(cur); err != nil {
 				result.Err = err
 
 				return result
 			}
 
-			if next == '/' { // close tag
-				tag, err := x.closeTagName()
-				if err != nil {
-					result.Err = err
-
-					return result
+			x.scratchInnerText.add(cur)
+		}
+	}
+}
+
+// rawSince returns the deferred bytes read from offset, the text of the last tag.
+func (x *XMLParser) rawSince(offset int) string {
+	if !x.deffer || offset < 0 || offset > len(x.scratchWriter.bytes()) {
+		return ""
+	}
+
+	return string(x.scratchWriter.bytes()[offset:])
+}
+
+// flushText adds the text read since the last node to the content of element.
+func (x *XMLParser) flushText(element *XMLElement) {
+	if len(x.scratchInnerText.bytes()) == 0 {
+		return
+	}
+
+	raw := string(x.scratchInnerText.bytes())
+
+	element.AppendNode(&Node{Kind: TextNode, Data: unescape(raw), raw: raw})
+	x.scratchInnerText.reset()
+}
+
+// pushElement opens an element: its namespace declarations come into scope
+// and it becomes the last step of the current path.
+func (x *XMLParser) pushElement(element *XMLElement) {
+	x.namespaces.push(element)
+	x.path = append(x.path, element)
+	element.depth = len(x.path)
+}
+
+// popElement closes the innermost open element.
+func (x *XMLParser) popElement() {
+	x.namespaces.pop()
+
+	if len(x.path) > 0 {
+		x.path[len(x.path)-1] = nil
+		x.path = x.path[:len(x.path)-1]
+	}
+}
+
+// lookupCallbacks returns the registrations whose path expression selects the innermost open element.
+func (x *XMLParser) lookupCallbacks() []loopElement {
+	var candidates []loopElement
+
+	for _, loop := range x.loopElements {
+		if loop.pattern.match(x.path) {
+			candidates = append(candidates, loop)
+		}
+	}
+
+	return candidates
+}
+
+// selectCallback returns the callback of the first candidate whose predicate accepts the element.
+func (x *XMLParser) selectCallback(candidates []loopElement, element *XMLElement) (CallbackNodesContext, bool) {
+	for _, candidate := range candidates {
+		if candidate.predicate == nil || candidate.predicate(element) {
+			return candidate.callback, true
+		}
+	}
+
+	return nil, false
+}
+
+// isCloseTag reports whether the element read by startElement is a close tag.
+func (x *XMLParser) isCloseTag(element *XMLElement) bool {
+	return strings.HasPrefix(element.Name, "/")
+}
+
+// skipElement consumes the content of the open element elname up to its matching close tag,
+// counting nested elements of the same name.
+func (x *XMLParser) skipElement(elname string) error {
+	var (
+		c       byte
+		next    byte
+		err     error
+		curname string
+		closed  bool
+	)
+
+	depth := 1
+	// in strict mode the names of the open elements are stacked to check their balance
+	open := []string{}
+
+	for {
+		c, err = x.readByte()
+
+		if err != nil {
+			return x.eofError(err, ErrUnexpectedEOF)
+		}
+
+		if c != '<' {
+			if err = x.checkText(c); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err = x.checkTextEnd(); err != nil {
+			return err
+		}
+
+		next, err = x.readByte()
+
+		if err != nil {
+			return x.eofError(err, ErrUnexpectedEOF)
+		}
+
+		switch next {
+		case '/':
+			curname, err = x.closeTagName()
+			if err != nil {
+				return err
+			}
+
+			if x.strict && len(open) > 0 {
+				if curname != open[len(open)-1] {
+					return x.parseError(ErrMismatchedTag)
 				}
 
-				if tag == result.Name {
-					result.InnerText = string(x.scratchInnerText.bytes())
-					x.scratchInnerText.reset()
+				open = open[:len(open)-1]
 
-					return result
-				}
-			} else {
-				err = x.unreadByte()
-				if err != nil {
-					return nil
-				}
+				continue
 			}
 
-			x.defferWrite()
-			element, tagClosed, err = x.startElement()
-
+			err = x.checkCloseTag(curname)
 			if err != nil {
-				result.Err = err
-
-				return result
+				return err
 			}
 
-			if _, ok := x.skipElements[element.Name]; ok && !tagClosed {
-				err = x.skipElement(element.Name)
-				if err != nil {
-					result.Err = err
+			if curname == elname {
+				depth--
+			}
 
-					return result
-				}
+			if depth == 0 {
+				x.popElement()
 
-				continue
+				return nil
 			}
-
-			if !tagClosed {
-				element = x.getElementTree(element)
+		case '!', '?':
+			err = x.skipMarkup(next)
+			if err != nil {
+				return err
 			}
-
-			if x.xpathEnabled {
-				element.parent = result
+		default:
+			err = x.unreadByte()
+			if err != nil {
+				return err
 			}
 
-			if _, ok := result.Childs[element.Name]; ok {
-				result.Childs[element.Name] = append(result.Childs[element.Name], *element)
-				if x.xpathEnabled {
-					result.childs = append(result.childs, element)
-				}
-			} else {
-				var childs []XMLElement
-				childs = append(childs, *element)
-				if result.Childs == nil {
-					result.Childs = map[string][]XMLElement{}
-				}
-				result.Childs[element.Name] = childs
+			curname, closed, err = x.skipStartTag()
+			if err != nil {
+				return err
+			}
 
-				if x.xpathEnabled {
-					result.childs = append(result.childs, element)
-				}
+			if x.strict && !closed {
+				open = append(open, curname)
+			} else if curname == elname && !closed {
+				depth++
 			}
-		} else {
-			x.scratchInnerText.add(cur)
 		}
 	}
 }
 
-func (x *XMLParser) skipElement(elname string) error {
+// skipStartTag consumes a start tag and returns its name and whether it is self-closing.
+func (x *XMLParser) skipStartTag() (string, bool, error) {
+	x.scratch.reset()
+
 	var (
-		c       byte
-		next    byte
-		err     error
-		curname string
+		c     byte
+		prev  byte
+		quote byte
+		name  string
+		err   error
 	)
 
 	for {
 		c, err = x.readByte()
+		if err != nil {
+			return "", false, x.eofError(err, ErrUnexpectedEOF)
+		}
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			if name == "" {
+				name = strings.TrimSuffix(string(x.scratch.bytes()), "/")
+			}
+
+			return name, prev == '/', nil
+		case isWS(c):
+			if name == "" {
+				name = string(x.scratch.bytes())
+			}
+		default:
+			if name == "" {
+				x.scratch.add(c)
+			}
+		}
+
+		prev = c
+	}
+}
 
+// skipMarkup consumes a comment, a CDATA section, a processing instruction or a declaration
+// whose first byte after '<' is kind.
+func (x *XMLParser) skipMarkup(kind byte) error {
+	terminator := ">"
+
+	if kind == '!' {
+		head, err := x.reader.Peek(2)
 		if err != nil {
-			return err
+			return x.eofError(err, ErrUnexpectedEOF)
 		}
 
-		if c == '<' {
-			next, err = x.readByte()
+		switch {
+		case string(head) == "--":
+			terminator = commentEnd
+		case string(head) == "[C":
+			terminator = cdataEnd
+		}
+	}
 
-			if err != nil {
+	x.scratch.reset()
+
+	for {
+		c, err := x.readByte()
+		if err != nil {
+			return x.eofError(err, ErrUnexpectedEOF)
+		}
+
+		// the body of a comment follows the "--" of its start
+		if body := x.scratch.bytes(); terminator == commentEnd && len(body) >= 2 {
+			if err = x.checkComment(body[2:], c); err != nil {
 				return err
 			}
+		}
 
-			if next == '/' {
-				curname, err = x.closeTagName()
-				if err != nil {
-					return err
-				}
+		x.scratch.add(c)
 
-				if curname == elname {
-					return nil
-				}
-			}
+		if c == '>' && strings.HasSuffix(string(x.scratch.bytes()), terminator) {
+			return nil
 		}
 	}
 }
@@ -399,6 +1271,10 @@ func (x *XMLParser) startElement() (*XMLElement, bool, error) {
 		// a tag have 3 forms * <abc > ** <abc type="foo" val="bar"/> *** <abc />
 		attr    string
 		attrVal string
+		// afterName is true when whitespace follows an attribute name
+		afterName bool
+		// afterValue is true right after the closing quote of an attribute value
+		afterValue bool
 	)
 
 	result := &XMLElement{}
@@ -407,24 +1283,19 @@ func (x *XMLParser) startElement() (*XMLElement, bool, error) {
 		cur, err = x.readByte()
 
 		if err != nil {
-			return nil, false, x.defaultError()
+			return nil, false, x.eofError(err, ErrUnexpectedEOF)
 		}
 
-		if x.isWS(cur) {
+		if isWS(cur) {
 			result.Name = string(x.scratch.bytes())
 
-			if x.xpathEnabled {
-				names := strings.Split(result.Name, ":")
-				if len(names) > 1 {
-					result.prefix = names[0]
-					result.localName = names[1]
-				} else {
-					result.localName = names[0]
-				}
-			}
-
 			x.scratch.reset()
 
+			err = x.checkName(strings.TrimPrefix(result.Name, "/"))
+			if err != nil {
+				return nil, false, err
+			}
+
 			goto search_close_tag
 		}
 
@@ -432,33 +1303,22 @@ func (x *XMLParser) startElement() (*XMLElement, bool, error) {
 		if cur == '>' {
 			if prev == '/' {
 				result.Name = string(x.scratch.bytes()[:len(x.scratch.bytes())-1])
-				result.autoClosable = true
-				result.outerTextBefore = string(x.scratchInnerText.bytes())
-				x.scratchInnerText.reset()
 
-				if x.xpathEnabled {
-					names := strings.Split(result.Name, ":")
-					if len(names) > 1 {
-						result.prefix = names[0]
-						result.localName = names[1]
-					} else {
-						result.localName = names[0]
-					}
+				err = x.checkName(result.Name)
+				if err != nil {
+					return nil, false, err
 				}
 
+				result.autoClosable = true
+
 				return result, true, nil
 			}
 
 			result.Name = string(x.scratch.bytes())
 
-			if x.xpathEnabled {
-				names := strings.Split(result.Name, ":")
-				if len(names) > 1 {
-					result.prefix = names[0]
-					result.localName = names[1]
-				} else {
-					result.localName = names[0]
-				}
+			err = x.checkName(strings.TrimPrefix(result.Name, "/"))
+			if err != nil {
+				return nil, false, err
 			}
 
 			return result, false, nil
@@ -473,41 +1333,66 @@ func (x *XMLParser) startElement() (*XMLElement, bool, error) {
 		cur, err = x.readByte()
 
 		if err != nil {
-			return nil, false, x.defaultError()
+			return nil, false, x.eofError(err, ErrUnexpectedEOF)
 		}
 
-		if x.isWS(cur) {
+		if isWS(cur) {
+			afterName = len(x.scratch.bytes()) > 0
+			afterValue = false
+
 			continue
 		}
+
+		if x.strict && afterValue && cur != '>' && cur != '/' {
+			return nil, false, x.parseError(ErrMissingWhitespace)
+		}
+
+		afterValue = false
+
 		if cur == '=' {
+			afterName = false
+
+			// whitespace may follow the equal sign
 			cur, err = x.readByte()
+			for err == nil && isWS(cur) {
+				cur, err = x.readByte()
+			}
 
 			if err != nil {
-				return nil, false, x.defaultError()
+				return nil, false, x.eofError(err, ErrUnexpectedEOF)
 			}
 
 			if !(cur == '"' || cur == '\'') {
-				return nil, false, x.defaultError()
+				return nil, false, x.parseError(ErrUnquotedAttribute)
 			}
 
 			attr = string(x.scratch.bytes())
 			attrVal, err = x.string(cur)
 			if err != nil {
-				return nil, false, x.defaultError()
+				return nil, false, x.eofError(err, ErrUnterminatedAttribute)
+			}
+
+			err = x.checkAttribute(result, attr, attrVal)
+			if err != nil {
+				return nil, false, err
 			}
 
-			result.AddAttribute(Attribute{attr, attrVal, ParseQuoteType(cur)})
+			result.AddAttribute(Attribute{Name: attr, Value: unescape(attrVal), Quote: ParseQuoteType(cur)})
 
 			x.scratch.reset()
 
+			afterValue = true
+
 			continue
 		}
 
 		if cur == '>' { // if tag name not found
+			if x.strict && len(x.scratch.bytes()) > 0 && string(x.scratch.bytes()) != "/" {
+				return nil, false, x.parseError(ErrAttributeWithoutValue)
+			}
+
 			if prev == '/' { // tag special close
 				result.autoClosable = true
-				result.outerTextBefore = string(x.scratchInnerText.bytes())
-				x.scratchInnerText.reset()
 
 				return result, true, nil
 			}
@@ -515,6 +1400,10 @@ func (x *XMLParser) startElement() (*XMLElement, bool, error) {
 			return result, false, nil
 		}
 
+		if x.strict && afterName {
+			return nil, false, x.parseError(ErrAttributeWithoutValue)
+		}
+
 		x.scratch.add(cur)
 		prev = cur
 	}
@@ -529,7 +1418,7 @@ func (x *XMLParser) readComment() (bool, error) {
 	c, err = x.readByte()
 
 	if err != nil {
-		return false, err
+		return false, x.eofError(err, ErrUnexpectedEOF)
 	}
 
 	if c != '!' {
@@ -546,19 +1435,17 @@ func (x *XMLParser) readComment() (bool, error) {
 	d, err = x.readByte()
 
 	if err != nil {
-		return false, err
+		return false, x.eofError(err, ErrUnexpectedEOF)
 	}
 
 	e, err = x.readByte()
 
 	if err != nil {
-		return false, err
+		return false, x.eofError(err, ErrUnexpectedEOF)
 	}
 
 	if d != '-' || e != '-' {
-		err = x.defaultError()
-
-		return false, err
+		return false, x.parseError(ErrInvalidComment)
 	}
 
 	// skip part
@@ -568,142 +1455,99 @@ func (x *XMLParser) readComment() (bool, error) {
 		c, err = x.readByte()
 
 		if err != nil {
-			return false, err
+			return false, x.eofError(err, ErrUnexpectedEOF)
 		}
 
 		if c == '>' &&
 			len(x.scratch.bytes()) > 1 &&
 			x.scratch.bytes()[len(x.scratch.bytes())-1] == '-' &&
 			x.scratch.bytes()[len(x.scratch.bytes())-2] == '-' {
-			x.scratchInnerText.add('<')
-			x.scratchInnerText.add('!')
-			x.scratchInnerText.add('-')
-			x.scratchInnerText.add('-')
-
-			for _, c := range x.scratch.bytes() {
-				x.scratchInnerText.add(c)
-			}
-
-			x.scratchInnerText.add('>')
-
-			// x.scratch2.reset()
-			x.scratch.reset()
+			// the body of the comment is left in scratch
+			x.scratch.unadd()
+			x.scratch.unadd()
 
 			return true, nil
 		}
 
+		if err = x.checkComment(x.scratch.bytes(), c); err != nil {
+			return false, err
+		}
+
 		x.scratch.add(c)
 	}
 }
 
-func (x *XMLParser) isCDATA() (bool, []byte, error) {
-	var (
-		c   byte
-		b   []byte
-		err error
-	)
-
-	b, err = x.reader.Peek(2)
-
-	if err != nil {
-		return false, nil, err
-	}
-
-	if b[0] != '!' {
-		return false, nil, nil
-	}
-
-	if err != nil {
-		return false, nil, err
-	}
-
-	if b[1] != '[' {
-		return false, nil, nil
-	}
-
-	// read peaked byte
-	_, err = x.readByte()
-
-	if err != nil {
-		return false, nil, err
-	}
-
-	_, err = x.readByte()
-
+// readProcInst consumes a processing instruction following '<', leaving its target and instruction
+// in scratch. It reports false, consuming nothing, when the next byte does not start one.
+func (x *XMLParser) readProcInst() (bool, error) {
+	head, err := x.reader.Peek(1)
 	if err != nil {
-		return false, nil, err
+		return false, x.eofError(err, ErrUnexpectedEOF)
 	}
 
-	c, err = x.readByte()
-
-	if err != nil {
-		return false, nil, err
+	if head[0] != '?' {
+		return false, nil
 	}
 
-	if c != 'C' {
-		err = x.defaultError()
-
-		return false, nil, err
+	if _, err = x.readByte(); err != nil {
+		return false, err
 	}
 
-	c, err = x.readByte()
-
-	if err != nil {
-		return false, nil, err
-	}
+	x.scratch.reset()
 
-	if c != 'D' {
-		err = x.defaultError()
+	for {
+		c, err := x.readByte()
+		if err != nil {
+			return false, x.eofError(err, ErrUnexpectedEOF)
+		}
 
-		return false, nil, err
-	}
+		if c == '>' && len(x.scratch.bytes()) > 0 && x.scratch.bytes()[len(x.scratch.bytes())-1] == '?' {
+			x.scratch.unadd()
 
-	c, err = x.readByte()
+			return true, x.checkProcInst(string(x.scratch.bytes()))
+		}
 
-	if err != nil {
-		return false, nil, err
+		x.scratch.add(c)
 	}
+}
 
-	if c != 'A' {
-		err = x.defaultError()
-
-		return false, nil, err
-	}
+func (x *XMLParser) isCDATA() (bool, []byte, error) {
+	var (
+		c   byte
+		b   []byte
+		err error
+	)
 
-	c, err = x.readByte()
+	b, err = x.reader.Peek(2)
 
 	if err != nil {
-		return false, nil, err
+		return false, nil, x.eofError(err, ErrUnexpectedEOF)
 	}
 
-	if c != 'T' {
-		err = x.defaultError()
-
-		return false, nil, err
-	}
-
-	c, err = x.readByte()
-
-	if err != nil {
-		return false, nil, err
+	if b[0] != '!' || b[1] != '[' {
+		return false, nil, nil
 	}
 
-	if c != 'A' {
-		err = x.defaultError()
+	// read peaked bytes
+	for i := 0; i < 2; i++ {
+		_, err = x.readByte()
 
-		return false, nil, err
+		if err != nil {
+			return false, nil, err
+		}
 	}
 
-	c, err = x.readByte()
-
-	if err != nil {
-		return false, nil, err
-	}
+	// the marker is <![CDATA[
+	for _, expected := range []byte(cdataStart[3:]) {
+		c, err = x.readByte()
 
-	if c != '[' {
-		err = x.defaultError()
+		if err != nil {
+			return false, nil, x.eofError(err, ErrUnexpectedEOF)
+		}
 
-		return false, nil, err
+		if c != expected {
+			return false, nil, x.parseError(ErrInvalidCDATA)
+		}
 	}
 
 	// this is possibly cdata // ]]>
@@ -713,7 +1557,7 @@ func (x *XMLParser) isCDATA() (bool, []byte, error) {
 		c, err = x.readByte()
 
 		if err != nil {
-			return false, nil, err
+			return false, nil, x.eofError(err, ErrUnexpectedEOF)
 		}
 
 		if c == '>' &&
@@ -767,13 +1611,13 @@ func (x *XMLParser) skipDeclerations() error {
 				c, err = x.readByte()
 
 				if err != nil {
-					return err
+					return x.eofError(err, ErrUnexpectedEOF)
 				}
 
 				d, err = x.readByte()
 
 				if err != nil {
-					return err
+					return x.eofError(err, ErrUnexpectedEOF)
 				}
 
 				if c == '-' && d == '-' {
@@ -787,11 +1631,16 @@ func (x *XMLParser) skipDeclerations() error {
 		}
 
 		// read peaked byte
-		_, err = x.readByte()
+		c, err = x.readByte()
 
 		if err != nil {
 			return err
 		}
+
+		err = x.checkOutsideRoot(c)
+		if err != nil {
+			return err
+		}
 	}
 
 skipComment:
@@ -801,7 +1650,7 @@ func (x *XMLParser) skipDeclerations() error {
 		c, err = x.readByte()
 
 		if err != nil {
-			return err
+			return x.eofError(err, ErrUnexpectedEOF)
 		}
 
 		if c == '>' &&
@@ -811,22 +1660,36 @@ func (x *XMLParser) skipDeclerations() error {
 			goto scan_declartions
 		}
 
+		if err = x.checkComment(x.scratch.bytes(), c); err != nil {
+			return err
+		}
+
 		x.scratch.add(c)
 	}
 
 skipDecleration:
 	depth := 1
 
+	x.scratch.reset()
+	x.scratch.add(c)
+	x.scratch.add(d)
+
 	for {
 		c, err = x.readByte()
 
 		if err != nil {
-			return err
+			return x.eofError(err, ErrUnexpectedEOF)
 		}
 
+		x.scratch.add(c)
+
 		if c == '>' {
 			depth--
 			if depth == 0 {
+				if bytes.HasPrefix(x.scratch.bytes(), []byte("DOCTYPE")) {
+					x.text.entities = parseEntityDecls(x.scratch.bytes())
+				}
+
 				goto scan_declartions
 			}
 
@@ -851,14 +1714,14 @@ func (x *XMLParser) closeTagName() (string, error) {
 		c, err = x.readByte()
 
 		if err != nil {
-			return "", err
+			return "", x.eofError(err, ErrUnexpectedEOF)
 		}
 
 		if c == '>' {
 			return string(x.scratch.bytes()), nil
 		}
 
-		if !x.isWS(c) {
+		if !isWS(c) {
 			x.scratch.add(c)
 		}
 	}
@@ -906,6 +1769,22 @@ func (x *XMLParser) readByte() (byte, error) {
 	}
 
 	x.TotalReadSize++
+	x.lastByte = by
+
+	if by == '\n' {
+		x.line++
+		x.lastColumn = x.column
+		x.column = 0
+	} else {
+		x.column++
+	}
+
+	if x.strict {
+		err = x.checkChar(by)
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	return by, nil
 }
@@ -916,16 +1795,27 @@ func (x *XMLParser) unreadByte() error {
 		return err
 	}
 
-	if x.nextWrite != nil {
+	if x.deffer {
+		x.scratchWriter.unadd()
+	} else if x.nextWrite != nil {
 		x.nextWrite = nil
 	}
 
 	x.TotalReadSize--
+	x.decoder = x.prevDecoder
+
+	if x.lastByte == '\n' {
+		x.line--
+		x.column = x.lastColumn
+	} else {
+		x.column--
+	}
 
 	return nil
 }
 
-func (x *XMLParser) isWS(in byte) bool {
+// isWS reports whether in is an XML whitespace character.
+func isWS(in byte) bool {
 	if in == ' ' || in == '\n' || in == '\t' || in == '\r' {
 		return true
 	}
@@ -933,12 +1823,48 @@ func (x *XMLParser) isWS(in byte) bool {
 	return false
 }
 
-func (x *XMLParser) defaultError() error {
-	err := fmt.Errorf("invalid xml")
+// parseError returns a ParseError located at the current position.
+func (x *XMLParser) parseError(reason error) error {
+	return &ParseError{
+		Line:   x.line,
+		Column: x.column,
+		Offset: x.TotalReadSize,
+		Path:   x.openPath(),
+		Err:    reason,
+	}
+}
+
+// interruptedError returns an InterruptedError located at the current position.
+func (x *XMLParser) interruptedError(err error) error {
+	return &InterruptedError{
+		Line:   x.line,
+		Column: x.column,
+		Offset: x.TotalReadSize,
+		Path:   x.openPath(),
+		Err:    err,
+	}
+}
+
+// eofError turns the end of input into a ParseError with the given reason, other errors are kept.
+func (x *XMLParser) eofError(err error, reason error) error {
+	if errors.Is(err, io.EOF) {
+		return x.parseError(reason)
+	}
 
 	return err
 }
 
+// openPath returns the names of the open elements, document element first.
+func (x *XMLParser) openPath() []string {
+	path := make([]string, 0, len(x.path))
+
+	for _, element := range x.path {
+		path = append(path, element.Name)
+	}
+
+	return path
+}
+
 func (x *XMLParser) string(start byte) (string, error) {
 	x.scratch.reset()
 
@@ -950,9 +1876,7 @@ func (x *XMLParser) string(start byte) (string, error) {
 	for {
 		c, err = x.readByte()
 		if err != nil {
-			if err != nil {
-				return "", err
-			}
+			return "", err
 		}
 
 		if c == start {
@@ -994,3 +1918,17 @@ func (s *scratch) add(c byte) {
 	s.data[s.fill] = c
 	s.fill++
 }
+
+// remove the last byte of scratch buffer.
+func (s *scratch) unadd() {
+	if s.fill > 0 {
+		s.fill--
+	}
+}
+
+// append a string to scratch buffer.
+func (s *scratch) addString(str string) {
+	for i := 0; i < len(str); i++ {
+		s.add(str[i])
+	}
+}
@@ -0,0 +1,110 @@
+package xixo_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/CGI-FR/xixo/pkg/xixo"
+)
+
+// benchmarkDocument returns a document of records, each with an address and a long description.
+func benchmarkDocument(records int) []byte {
+	var builder strings.Builder
+
+	builder.WriteString("<?xml version=\"1.0\"?>\n<root>\n")
+
+	for i := 0; i < records; i++ {
+		fmt.Fprintf(&builder, "  <record id=\"%d\">\n", i)
+		fmt.Fprintf(&builder, "    <name>name %d</name>\n", i)
+		builder.WriteString("    <address><street>1 main street</street><city>Nantes</city></address>\n")
+		fmt.Fprintf(&builder, "    <description><!-- text -->%s</description>\n", strings.Repeat("lorem ipsum ", 40))
+		builder.WriteString("  </record>\n")
+	}
+
+	builder.WriteString("</root>\n")
+
+	return []byte(builder.String())
+}
+
+func benchmarkStream(b *testing.B, register func(parser *xixo.XMLParser)) {
+	b.Helper()
+
+	input := benchmarkDocument(2000)
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		parser := xixo.NewXMLParser(bytes.NewReader(input), io.Discard)
+		register(parser)
+
+		if err := parser.Stream(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func identity(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+	return elem, nil
+}
+
+// BenchmarkStreamPassthrough copies a document without any callback.
+func BenchmarkStreamPassthrough(b *testing.B) {
+	benchmarkStream(b, func(parser *xixo.XMLParser) {})
+}
+
+// BenchmarkStreamAbsolutePath transforms a single element, the other records being unmatched regions.
+func BenchmarkStreamAbsolutePath(b *testing.B) {
+	benchmarkStream(b, func(parser *xixo.XMLParser) {
+		parser.RegisterCallback("/root/header", identity)
+	})
+}
+
+// BenchmarkStreamRelativePath transforms an element at any depth absent from the records, which are copied
+// as subtrees once scanned ahead.
+func BenchmarkStreamRelativePath(b *testing.B) {
+	benchmarkStream(b, func(parser *xixo.XMLParser) {
+		parser.RegisterCallback("header", identity)
+	})
+}
+
+// BenchmarkStreamNestedMatch transforms an element nested in every record.
+func BenchmarkStreamNestedMatch(b *testing.B) {
+	benchmarkStream(b, func(parser *xixo.XMLParser) {
+		parser.RegisterCallback("address", identity)
+	})
+}
+
+// BenchmarkStreamEveryRecord transforms every record.
+func BenchmarkStreamEveryRecord(b *testing.B) {
+	benchmarkStream(b, func(parser *xixo.XMLParser) {
+		parser.RegisterCallback("record", identity)
+	})
+}
+
+// BenchmarkElementWriteTo serializes a large element tree.
+func BenchmarkElementWriteTo(b *testing.B) {
+	var root *xixo.XMLElement
+
+	parser := xixo.NewXMLParser(bytes.NewReader(benchmarkDocument(2000)), io.Discard).EnableXpath()
+	parser.RegisterCallback("/root", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		root = elem
+
+		return elem, nil
+	})
+
+	if err := parser.Stream(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := root.WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
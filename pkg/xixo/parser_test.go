@@ -2,7 +2,15 @@
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/CGI-FR/xixo/pkg/xixo"
 	"github.com/stretchr/testify/assert"
@@ -352,3 +360,1534 @@ func TestModifyShouldPreserveIndentForElementInline(t *testing.T) {
 
 	assert.Equal(t, expectedResultXML, resultXML)
 }
+
+func TestEntitiesShouldBeDecodedForCallbacks(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><name title="Tom &amp; Jerry &#x26; co">Tom &amp; Jerry &lt;3 &#169;</name></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterCallback("name", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Equal(t, "Tom & Jerry <3 ©", elem.InnerText)
+		assert.Equal(t, "Tom & Jerry & co", elem.Attrs["title"].Value)
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	// unchanged values are written as they were read
+	assert.Equal(t, inputXML, resultXMLBuffer.String())
+}
+
+func TestEntitiesShouldBeEscapedAfterCallback(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><name title='x' id="y">Tom</name></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterCallback("name", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		elem.InnerText = `<Tom & "Jerry">`
+		elem.AddAttribute(xixo.Attribute{Name: "title", Value: `it's <"me">`})
+		elem.AddAttribute(xixo.Attribute{Name: "id", Value: `it's <"me">`})
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<root><name title='it&apos;s &lt;"me">' id="it's &lt;&quot;me&quot;>">&lt;Tom &amp; "Jerry"&gt;</name></root>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestWhitespaceReferencesShouldRoundTripAfterCallback(t *testing.T) {
+	t.Parallel()
+
+	inputXML := "<root><a v='x&#10;y&#13;z&#9;w' id=\"1\">l1&#13;\nl2\tend</a></root>"
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer)
+	parser.RegisterCallback("a", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Equal(t, "x\ny\rz\tw", elem.Attrs["v"].Value)
+		assert.Equal(t, "l1\r\nl2\tend", elem.InnerText)
+
+		// the tag and the text are written again
+		elem.AddAttribute(xixo.Attribute{Name: "id", Value: "2"})
+		elem.InnerText += "."
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := "<root><a v='x&#10;y&#13;z&#9;w' id=\"2\">l1&#13;\nl2\tend.</a></root>"
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestUnknownEntityReferencesShouldBeKeptAfterCallback(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<!DOCTYPE r [<!ENTITY co "ACME">]><r><u name="x" org="&co;">Tom &co;&nbsp;&amp;</u></r>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer)
+	parser.RegisterMapCallback("u", func(m map[string]string) (map[string]string, error) {
+		assert.Equal(t, "&co;", m["@org"])
+
+		m["@name"] = "y"
+
+		return m, nil
+	})
+	parser.RegisterCallback("r", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		elem.AddAttribute(xixo.Attribute{Name: "by", Value: "&co; & &#1;"})
+		elem.FirstChild().InnerText = "XXX " + elem.FirstChild().InnerText
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<!DOCTYPE r [<!ENTITY co "ACME">]><r by="&co; &amp; &amp;#1;"><u name="y" org="&co;">` +
+		`XXX Tom &co;&nbsp;&amp;</u></r>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestMapCallbackShouldDecodeAndEscapeEntities(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><name>Tom &amp; Jerry</name><city>A&amp;B <!-- note --></city></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterMapCallback("root", func(m map[string]string) (map[string]string, error) {
+		assert.Equal(t, "Tom & Jerry", m["name"])
+		assert.Equal(t, "A&B ", m["city"])
+
+		m["name"] = "Jerry & Tom"
+
+		return m, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<root><name>Jerry &amp; Tom</name><city>A&amp;B <!-- note --></city></root>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestCDATAShouldBeKeptWhenUnchanged(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><name><![CDATA[a < b & c]]></name></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterCallback("name", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Equal(t, "a < b & c", elem.InnerText)
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	assert.Equal(t, inputXML, resultXMLBuffer.String())
+}
+
+func TestCallbackShouldMatchExpandedNameWhateverThePrefix(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<Envelope xmlns="urn:envelope">
+	<a:Document xmlns:a="urn:iso:pain"><a:Nm>first</a:Nm></a:Document>
+	<b:Document xmlns:b="urn:iso:pain"><b:Nm>second</b:Nm></b:Document>
+	<c:Document xmlns:c="urn:other"><c:Nm>third</c:Nm></c:Document>
+</Envelope>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterCallback("{urn:iso:pain}Document", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Equal(t, "urn:iso:pain", elem.NamespaceURI())
+		assert.Equal(t, "Document", elem.LocalName())
+		assert.Equal(t, "{urn:iso:pain}Document", elem.ExpandedName())
+
+		child := elem.FirstChild()
+		assert.Equal(t, "urn:iso:pain", child.NamespaceURI())
+		assert.Equal(t, "Nm", child.LocalName())
+
+		child.InnerText = "masked"
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<Envelope xmlns="urn:envelope">
+	<a:Document xmlns:a="urn:iso:pain"><a:Nm>masked</a:Nm></a:Document>
+	<b:Document xmlns:b="urn:iso:pain"><b:Nm>masked</b:Nm></b:Document>
+	<c:Document xmlns:c="urn:other"><c:Nm>third</c:Nm></c:Document>
+</Envelope>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestNamespacesShouldBeScopedToTheirElement(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root xmlns="urn:default" xmlns:p="urn:p">
+	<p:item xmlns:p="urn:inner" p:id="1" id="2" xml:lang="fr">inner</p:item>
+	<p:item p:id="3">outer</p:item>
+	<item xmlns="">none</item>
+	<item>default</item>
+</root>`
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), io.Discard)
+
+	var (
+		itemURIs []string
+		attrURIs []string
+	)
+
+	parser.RegisterCallback("p:item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		itemURIs = append(itemURIs, elem.NamespaceURI())
+		attrURIs = append(attrURIs, elem.AttributeNamespaceURI("p:id"))
+
+		if _, ok := elem.Attrs["id"]; ok {
+			assert.Equal(t, "", elem.AttributeNamespaceURI("id"))
+			assert.Equal(t, xixo.XMLNamespaceURI, elem.AttributeNamespaceURI("xml:lang"))
+			assert.Equal(t, "lang", elem.Attrs["xml:lang"].LocalName())
+			assert.Equal(t, "xml", elem.Attrs["xml:lang"].Prefix())
+		}
+
+		return elem, nil
+	})
+	parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		itemURIs = append(itemURIs, elem.NamespaceURI())
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"urn:inner", "urn:p", "", "urn:default"}, itemURIs)
+	assert.Equal(t, []string{"urn:inner", "urn:p"}, attrURIs)
+}
+
+func TestCallbackShouldMatchPathExpressions(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root>
+	<customer><address>c1</address></customer>
+	<supplier><address>s1</address></supplier>
+	<customer><contact><address>c2</address></contact></customer>
+	<address>r1</address>
+</root>`
+
+	tests := []struct {
+		match    string
+		expected []string
+	}{
+		{match: "address", expected: []string{"c1", "s1", "c2", "r1"}},
+		{match: "//address", expected: []string{"c1", "s1", "c2", "r1"}},
+		{match: "customer/address", expected: []string{"c1"}},
+		{match: "/root/customer/address", expected: []string{"c1"}},
+		{match: "/root/address", expected: []string{"r1"}},
+		{match: "/address", expected: nil},
+		{match: "/root/customer//address", expected: []string{"c1", "c2"}},
+		{match: "customer/*/address", expected: []string{"c2"}},
+		{match: "/root/*/address", expected: []string{"c1", "s1"}},
+		{match: "contact/address", expected: []string{"c2"}},
+		{match: "{}address", expected: []string{"c1", "s1", "c2", "r1"}},
+		{match: "supplier/contact", expected: nil},
+	}
+
+	for _, testCase := range tests {
+		var found []string
+
+		parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), io.Discard)
+		parser.RegisterCallback(testCase.match, func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+			found = append(found, elem.InnerText)
+
+			return elem, nil
+		})
+
+		err := parser.Stream()
+		assert.Nil(t, err)
+		assert.Equal(t, testCase.expected, found, testCase.match)
+	}
+}
+
+func TestCallbackShouldMatchPathWithExpandedNames(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<a:Document xmlns:a="urn:iso:pain"><a:Cdtr><a:Nm>creditor</a:Nm></a:Cdtr>` +
+		`<a:Dbtr><a:Nm>debtor</a:Nm></a:Dbtr></a:Document>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer)
+	parser.RegisterCallback("/{urn:iso:pain}Document/{urn:iso:pain}Dbtr/{urn:iso:pain}Nm",
+		func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+			elem.InnerText = "masked"
+
+			return elem, nil
+		})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<a:Document xmlns:a="urn:iso:pain"><a:Cdtr><a:Nm>creditor</a:Nm></a:Cdtr>` +
+		`<a:Dbtr><a:Nm>masked</a:Nm></a:Dbtr></a:Document>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func maskName(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+	elem.FirstChild().InnerText = "masked"
+
+	return elem, nil
+}
+
+func TestPredicateShouldStreamRejectedElementsUntouched(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root>
+	<party role="customer"><name>Alice</name></party>
+	<party  role='bank' ><name >Bank &amp; co</name><!-- kept --><x/></party >
+	<party role="customer"><name>Bob</name></party>
+</root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.Register("party", xixo.IgnoreContext(maskName), xixo.WithPredicate(xixo.AttrEquals("role", "customer")))
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<root>
+	<party role="customer"><name>masked</name></party>
+	<party  role='bank' ><name >Bank &amp; co</name><!-- kept --><x/></party >
+	<party role="customer"><name>masked</name></party>
+</root>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestPredicateShouldFallBackToNextRegistration(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><party role="customer"><name>Alice</name></party><party role="bank"><name>Bank</name></party>` +
+		`<party><name>Other</name></party></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.Register("party", xixo.IgnoreContext(maskName), xixo.WithPredicate(xixo.AttrEquals("role", "customer")))
+	parser.Register("party", xixo.IgnoreContext(xixo.XMLElementToMapCallback(
+		func(m map[string]string) (map[string]string, error) {
+			m["name"] = "hidden"
+
+			return m, nil
+		})), xixo.WithPredicate(xixo.HasAttr("role")))
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<root><party role="customer"><name>masked</name></party><party role="bank"><name>hidden</name></party>` +
+		`<party><name>Other</name></party></root>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestPredicateShouldGuardEveryCallbackKind(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><party role="customer"><name>Alice</name></party><party role="bank"><name>Bank</name></party></root>`
+	isBank := xixo.WithPredicate(xixo.AttrEquals("role", "bank"))
+
+	hideJSON := func(_ context.Context, source string) (string, error) {
+		return strings.Replace(source, "Bank", "hidden", 1), nil
+	}
+
+	hideMap := func(_ context.Context, m map[string]string) (map[string]string, error) {
+		m["name"] = "hidden"
+
+		return m, nil
+	}
+
+	hideOrderedMap := func(_ context.Context, m *xixo.OrderedMap) (*xixo.OrderedMap, error) {
+		m.Set("name", "hidden")
+
+		return m, nil
+	}
+
+	registrations := map[string]func(parser *xixo.XMLParser){
+		"nodes": func(parser *xixo.XMLParser) {
+			parser.RegisterNodes("party", func(_ context.Context, elem *xixo.XMLElement) ([]*xixo.Node, error) {
+				elem.FirstChild().InnerText = "hidden"
+
+				return []*xixo.Node{{Kind: xixo.ElementNode, Element: elem}}, nil
+			}, isBank)
+		},
+		"json": func(parser *xixo.XMLParser) {
+			parser.Register("party", xixo.XMLElementToJSONCallbackContext(hideJSON), isBank)
+		},
+		"json tree": func(parser *xixo.XMLParser) {
+			parser.Register("party", xixo.XMLElementToJSONTreeCallbackContext(xixo.ParkerConvention, hideJSON), isBank)
+		},
+		"typed json": func(parser *xixo.XMLParser) {
+			parser.Register("party", xixo.XMLElementToTypedJSONCallbackContext(xixo.JSONOptions{}, hideJSON), isBank)
+		},
+		"typed json tree": func(parser *xixo.XMLParser) {
+			parser.Register("party", xixo.XMLElementToTypedJSONTreeCallbackContext(xixo.JSONOptions{}, hideJSON), isBank)
+		},
+		"ordered map": func(parser *xixo.XMLParser) {
+			parser.Register("party", xixo.XMLElementToOrderedMapCallbackContext(hideOrderedMap), isBank)
+		},
+		"map": func(parser *xixo.XMLParser) {
+			parser.Register("party", xixo.XMLElementToMapCallbackContext(hideMap), isBank)
+		},
+		"match": func(parser *xixo.XMLParser) {
+			parser.RegisterMatch("party", xixo.WithPredicate(xixo.AttrEquals("role", "customer")))
+			parser.RegisterMapCallback("party", func(m map[string]string) (map[string]string, error) {
+				m["name"] = "hidden"
+
+				return m, nil
+			})
+		},
+	}
+
+	expected := `<root><party role="customer"><name>Alice</name></party><party role="bank"><name>hidden</name></party></root>`
+
+	for kind, register := range registrations {
+		var resultXMLBuffer bytes.Buffer
+
+		parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer)
+		register(parser)
+
+		err := parser.Stream()
+		assert.Nil(t, err, kind)
+		assert.Equal(t, expected, resultXMLBuffer.String(), kind)
+	}
+}
+
+func TestPredicateHelpers(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><party role="customer"><name>Alice</name></party><party role="bank"><name>Bank</name></party></root>`
+
+	isBank, err := xixo.XPathPredicate("@role = 'bank' and name = 'Bank'")
+	assert.Nil(t, err)
+
+	_, err = xixo.XPathPredicate("@role = ")
+	assert.ErrorIs(t, err, xixo.ErrXPathSyntax)
+
+	tests := []struct {
+		predicate xixo.Predicate
+		expected  []string
+	}{
+		{predicate: xixo.ChildTextEquals("name", "Alice"), expected: []string{"customer"}},
+		{predicate: xixo.AtDepth(2), expected: []string{"customer", "bank"}},
+		{predicate: xixo.AtDepth(3), expected: nil},
+		{predicate: isBank, expected: []string{"bank"}},
+		{predicate: xixo.Not(isBank), expected: []string{"customer"}},
+		{predicate: xixo.And(xixo.AtDepth(2), xixo.AttrEquals("role", "bank")), expected: []string{"bank"}},
+		{predicate: xixo.Or(isBank, xixo.ChildTextEquals("name", "Alice")), expected: []string{"customer", "bank"}},
+	}
+
+	for _, testCase := range tests {
+		var found []string
+
+		parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), io.Discard).EnableXpath()
+		parser.Register("party", func(_ context.Context, elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+			found = append(found, elem.Attrs["role"].Value)
+
+			return elem, nil
+		}, xixo.WithPredicate(testCase.predicate))
+
+		err := parser.Stream()
+		assert.Nil(t, err)
+		assert.Equal(t, testCase.expected, found)
+	}
+}
+
+func TestCallbackShouldRunOnSelfClosingElements(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<users>
+	<user name="Alice" id="1"/>
+	<user name="Bob"  id="2" />
+	<user name="Carl" id="3" />
+	<user name='Dan'/>
+</users>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	called := 0
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterCallback("user", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		called++
+
+		switch elem.Attrs["name"].Value {
+		case "Alice":
+			elem.AddAttribute(xixo.Attribute{Name: "name", Value: "masked"})
+		case "Carl":
+			elem.InnerText = "content"
+		case "Dan":
+			elem.RemoveAttribute("name")
+		}
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, called)
+
+	expected := `<users>
+	<user name="masked" id="1"/>
+	<user name="Bob"  id="2" />
+	<user name="Carl" id="3">content</user>
+	<user/>
+</users>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestMapCallbackShouldRunOnSelfClosingElements(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<users><!-- first --><user name="Alice" age="22"/><user name="Bob" age="33"/></users>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.Register("user", xixo.XMLElementToMapCallbackContext(
+		func(_ context.Context, m map[string]string) (map[string]string, error) {
+			assert.Equal(t, map[string]string{"@name": "Alice", "@age": "22"}, m)
+
+			m["@age"] = "40"
+
+			return m, nil
+		}), xixo.WithPredicate(xixo.AttrEquals("name", "Alice")))
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<users><!-- first --><user name="Alice" age="40"/><user name="Bob" age="33"/></users>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestNestedLoopElementsShouldBeTransformedInnerFirst(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root>
+	<folder name="a">
+		<file>1</file>
+		<folder name="b">
+			<file>2</file>
+			<folder name="c"/>
+		</folder>
+	</folder>
+</root>`
+
+	var (
+		resultXMLBuffer bytes.Buffer
+		order           []string
+	)
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterCallback("folder", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		order = append(order, elem.Attrs["name"].Value)
+
+		// inner folders have already been renamed when the outer one is called
+		for child := elem.FirstChild(); child != nil; child = child.NextSibling() {
+			if child.Name == "folder" {
+				assert.Equal(t, "done-"+child.Attrs["name"].Value[5:], child.Attrs["name"].Value)
+			}
+		}
+
+		elem.AddAttribute(xixo.Attribute{Name: "name", Value: "done-" + elem.Attrs["name"].Value})
+
+		return elem, nil
+	})
+	parser.RegisterCallback("file", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		order = append(order, "file"+elem.InnerText)
+		elem.InnerText = "masked"
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"file1", "file2", "c", "b", "a"}, order)
+
+	expected := `<root>
+	<folder name="done-a">
+		<file>masked</file>
+		<folder name="done-b">
+			<file>masked</file>
+			<folder name="done-c"/>
+		</folder>
+	</folder>
+</root>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestNestedChangesShouldBeKeptWhenOuterPredicateRejects(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><party role="bank"><name>Bank</name></party><party role="customer"><name>Alice</name></party></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.Register("party",
+		func(_ context.Context, elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+			elem.AddAttribute(xixo.Attribute{Name: "checked", Value: "true"})
+
+			return elem, nil
+		}, xixo.WithPredicate(xixo.AttrEquals("role", "customer")))
+	parser.RegisterCallback("name", modifyElement1Content)
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	expected := `<root><party role="bank"><name>ContenuModifie</name></party>` +
+		`<party role="customer" checked="true"><name>ContenuModifie</name></party></root>`
+	assert.Equal(t, expected, resultXMLBuffer.String())
+}
+
+func TestSkipElementsShouldHandleNestedSameNameElements(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><item><secret><secret>a</secret><!-- </secret> --><b>x</b></secret><name>kept</name></item></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.SkipElements([]string{"secret"})
+	parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Len(t, elem.Children(), 1)
+		assert.Equal(t, "kept", elem.Child("name").InnerText)
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	assert.Equal(t, `<root><item><name>kept</name></item></root>`, resultXMLBuffer.String())
+}
+
+func TestNestedCallbackErrorShouldStopStream(t *testing.T) {
+	t.Parallel()
+
+	errNested := errors.New("nested failure")
+	outerCalled := false
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(`<root><a><b>x</b></a></root>`), io.Discard).EnableXpath()
+	parser.RegisterCallback("a", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		outerCalled = true
+
+		return elem, nil
+	})
+	parser.RegisterCallback("b", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		return nil, errNested
+	})
+
+	err := parser.Stream()
+	assert.ErrorIs(t, err, errNested)
+	assert.False(t, outerCalled)
+}
+
+func TestParseErrorShouldLocateMalformedInput(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		input  string
+		reason error
+		line   int
+		column int
+		path   []string
+	}{
+		{"unquoted attribute", "<root>\n  <item id=1>x</item>\n</root>", xixo.ErrUnquotedAttribute, 2, 12, []string{"root"}},
+		{"unterminated attribute", "<root>\n<item id=\"1>x</item></root>", xixo.ErrUnterminatedAttribute, 2, 27, []string{"root"}},
+		{"truncated element", "<root>\n<item>\n<name>x</na", xixo.ErrUnexpectedEOF, 3, 11, []string{"root", "item", "name"}},
+		{"invalid comment", "<root><item><!- x --></item></root>", xixo.ErrInvalidComment, 1, 16, []string{"root", "item"}},
+		{"invalid cdata", "<root><item><![CDAT[x]]></item></root>", xixo.ErrInvalidCDATA, 1, 20, []string{"root", "item"}},
+		{"nested comment", "<root><item><c><!- x --></c></item></root>", xixo.ErrInvalidComment, 1, 19, []string{"root", "item", "c"}},
+		{"nested cdata", "<root><item><c><![CDAT x]]></c></item></root>", xixo.ErrInvalidCDATA, 1, 23, []string{"root", "item", "c"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			parser := xixo.NewXMLParser(bytes.NewBufferString(tc.input), io.Discard).EnableXpath()
+			parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+				return elem, nil
+			})
+
+			err := parser.Stream()
+			assert.ErrorIs(t, err, tc.reason)
+
+			var parseErr *xixo.ParseError
+
+			assert.True(t, errors.As(err, &parseErr))
+			assert.Equal(t, tc.line, parseErr.Line)
+			assert.Equal(t, tc.column, parseErr.Column)
+			assert.Equal(t, tc.path, parseErr.Path)
+		})
+	}
+}
+
+func TestCallbackErrorShouldReportElementPath(t *testing.T) {
+	t.Parallel()
+
+	errInvalid := errors.New("invalid customer")
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString("<root>\n<customer><name>x</name></customer>\n</root>"), io.Discard)
+	parser.RegisterCallback("name", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		return nil, errInvalid
+	})
+
+	err := parser.Stream()
+	assert.ErrorIs(t, err, errInvalid)
+
+	var callbackErr *xixo.CallbackError
+
+	assert.True(t, errors.As(err, &callbackErr))
+	assert.Equal(t, "/root/customer/name", callbackErr.Path)
+	assert.Equal(t, 2, callbackErr.Line)
+	assert.Equal(t, 24, callbackErr.Column)
+}
+
+func TestStrictModeShouldRejectMalformedDocuments(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		input  string
+		reason error
+	}{
+		{"mismatched close tag", `<root><item><name>x</nom></item></root>`, xixo.ErrMismatchedTag},
+		{"mismatched outer close tag", `<root><other></root></other>`, xixo.ErrMismatchedTag},
+		{"mismatched skipped element", `<root><item><secret><a></b></secret></item></root>`, xixo.ErrMismatchedTag},
+		{"duplicate attribute", `<root><item id="1" id="2">x</item></root>`, xixo.ErrDuplicateAttribute},
+		{"attribute without value", `<root><item disabled>x</item></root>`, xixo.ErrAttributeWithoutValue},
+		{"attributes without value", `<root><item disabled checked="true">x</item></root>`, xixo.ErrAttributeWithoutValue},
+		{"unquoted attribute", `<root><item id=1>x</item></root>`, xixo.ErrUnquotedAttribute},
+		{"lower than in attribute", `<root><item id="<1>">x</item></root>`, xixo.ErrInvalidChar},
+		{"invalid element name", `<root><1item>x</1item></root>`, xixo.ErrInvalidName},
+		{"invalid attribute name", `<root><item a-"b="1">x</item></root>`, xixo.ErrInvalidName},
+		{"control character", "<root><item>a\x01b</item></root>", xixo.ErrInvalidChar},
+		{"invalid utf-8", "<root><item>a\xC3\x28b</item></root>", xixo.ErrInvalidChar},
+		{"unterminated document", `<root><item>x</item>`, xixo.ErrUnexpectedEOF},
+		{"empty document", `<?xml version="1.0"?>`, xixo.ErrUnexpectedEOF},
+		{"second root", `<root></root><root></root>`, xixo.ErrContentOutsideRoot},
+		{"text after root", `<root></root>text`, xixo.ErrContentOutsideRoot},
+		{"text before root", `text<root></root>`, xixo.ErrContentOutsideRoot},
+		{"bare ampersand", `<root><item>a & b</item></root>`, xixo.ErrInvalidReference},
+		{"bare ampersand outside items", `<root>a & b<item/></root>`, xixo.ErrInvalidReference},
+		{"unterminated reference", `<root><item>a &amp</item></root>`, xixo.ErrInvalidReference},
+		{"bare ampersand in attribute", `<root><item id="a & b"/></root>`, xixo.ErrInvalidReference},
+		{"cdata end in text", `<root><item>a ]]> b</item></root>`, xixo.ErrCDATAEndInText},
+		{"cdata end outside items", `<root>a ]]> b</root>`, xixo.ErrCDATAEndInText},
+		{"double hyphen in comment", `<root><item><!-- a -- b --></item></root>`, xixo.ErrInvalidComment},
+		{"double hyphen in outer comment", `<root><!-- a -- b --></root>`, xixo.ErrInvalidComment},
+		{"comment ending with hyphen", `<root><!-- a ---></root>`, xixo.ErrInvalidComment},
+		{"attributes without whitespace", `<root><item x='1'y='2'/></root>`, xixo.ErrMissingWhitespace},
+		{"reserved instruction target", `<root><?xml version="1.0"?></root>`, xixo.ErrInvalidName},
+		{"undeclared entity", `<root><item>&foo;</item></root>`, xixo.ErrInvalidReference},
+		{"undeclared entity in attribute", `<root><item id="&foo;"/></root>`, xixo.ErrInvalidReference},
+		{"entity declared by another name", `<!DOCTYPE root [<!ENTITY bar "x">]><root><item>&foo;</item></root>`,
+			xixo.ErrInvalidReference},
+		{"undeclared entity outside items", `<!DOCTYPE root SYSTEM "root.dtd"><root>&foo;<item/></root>`,
+			xixo.ErrInvalidReference},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			parser := xixo.NewXMLParser(bytes.NewBufferString(tc.input), io.Discard).Strict()
+			parser.SkipElements([]string{"secret"})
+			parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+				return elem, nil
+			})
+
+			err := parser.Stream()
+			assert.ErrorIs(t, err, tc.reason)
+
+			var parseErr *xixo.ParseError
+
+			assert.True(t, errors.As(err, &parseErr))
+		})
+	}
+}
+
+func TestStrictModeShouldAcceptWellFormedDocuments(t *testing.T) {
+	t.Parallel()
+
+	inputXML := "\xEF\xBB\xBF<?xml version=\"1.0\"?>\n<!-- header -->\n" +
+		"<!DOCTYPE ns:root [<!ENTITY co \"ACME\">]>\n" +
+		`<ns:root xmlns:ns="urn:a"><ns:item id = "1" label='a &amp; b'><név>é &co;</név><![CDATA[<x>]]></ns:item>` +
+		`<ns:item id="2" /><ns:item><ns:item>nested</ns:item></ns:item></ns:root>` + "\n<!-- footer -->\n"
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).Strict().EnableXpath()
+	parser.RegisterCallback("/ns:root/ns:item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	assert.Equal(t, inputXML, resultXMLBuffer.String())
+}
+
+func TestStrictModeShouldAcceptProcessingInstructions(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><?pi data?><item><?pi item data?>x &#x41; &lt;]]</item><?empty?></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).Strict()
+	parser.RegisterCallback("/root/item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Equal(t, xixo.ProcInstNode, elem.Nodes()[0].Kind)
+		assert.Equal(t, "pi item data", elem.Nodes()[0].Data)
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+
+	assert.Equal(t, inputXML, resultXMLBuffer.String())
+}
+
+func TestLenientModeShouldTolerateMalformedDocuments(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"mismatched close tag", `<root><item><name>x</nom></name></item></root>`, `<root><item>changed</item></root>`},
+		{"duplicate attribute", `<root><item id="1" id="2">x</item></root>`, `<root><item>changed</item></root>`},
+		{"unterminated document", `<root><item>x</item>`, `<root><item>changed</item>`},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultXMLBuffer bytes.Buffer
+
+			parser := xixo.NewXMLParser(bytes.NewBufferString(tc.input), &resultXMLBuffer)
+			parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+				return &xixo.XMLElement{Name: "item", InnerText: "changed"}, nil
+			})
+
+			err := parser.Stream()
+			assert.Nil(t, err)
+
+			assert.Equal(t, tc.expected, resultXMLBuffer.String())
+		})
+	}
+}
+
+func TestStreamShouldNotBlockOnManyMatches(t *testing.T) {
+	t.Parallel()
+
+	inputXML := "<root>" + strings.Repeat("<item>x</item>", 1000) + "</root>"
+	count := 0
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), io.Discard)
+	parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		count++
+
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, count)
+}
+
+func TestNextShouldReturnMatchedElements(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><item id="1"><name>a</name></item><other/><item id="2"/><item id="3"><name>c</name></item></root>`
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), nil)
+	parser.RegisterMatch("item")
+	parser.RegisterMatch("item/name")
+
+	names := []string{}
+
+	for {
+		elem, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		assert.Nil(t, err)
+
+		names = append(names, elem.Name+elem.Attrs["id"].Value+elem.InnerText)
+	}
+
+	// nested elements come before their ancestors
+	assert.Equal(t, []string{"namea", "item1", "item2", "namec", "item3"}, names)
+
+	_, err := parser.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestNextShouldNotWaitForLookahead(t *testing.T) {
+	t.Parallel()
+
+	reader, writer := io.Pipe()
+	parser := xixo.NewXMLParser(reader, nil)
+	parser.RegisterMatch("item")
+
+	go func() {
+		_, _ = writer.Write([]byte(`<root><other><x/></other><item id="1"/>`))
+	}()
+
+	found := make(chan *xixo.XMLElement)
+
+	go func() {
+		elem, _ := parser.Next()
+		found <- elem
+	}()
+
+	select {
+	case elem := <-found:
+		assert.Equal(t, "1", elem.Attrs["id"].Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next is waiting for more input than the matched element")
+	}
+
+	writer.Close()
+}
+
+func TestNextShouldApplyCallbacksAndWriteOutput(t *testing.T) {
+	t.Parallel()
+
+	inputXML := `<root><item><name>a</name></item><skipped><name>b</name></skipped><item><name>c</name></item></root>`
+
+	var resultXMLBuffer bytes.Buffer
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer).EnableXpath()
+	parser.RegisterMatch("skipped")
+	parser.RegisterMapCallback("item", func(m map[string]string) (map[string]string, error) {
+		m["name"] = strings.ToUpper(m["name"])
+
+		return m, nil
+	})
+
+	names := []string{}
+
+	for elem, err := range parser.Elements() {
+		assert.Nil(t, err)
+
+		names = append(names, elem.Name)
+	}
+
+	assert.Equal(t, []string{"item", "skipped", "item"}, names)
+	assert.Equal(t,
+		`<root><item><name>A</name></item><skipped><name>b</name></skipped><item><name>C</name></item></root>`,
+		resultXMLBuffer.String())
+}
+
+func TestElementsShouldYieldParseErrors(t *testing.T) {
+	t.Parallel()
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(`<root><item>a</item><item id=1>b</item></root>`), nil)
+	parser.RegisterMatch("item")
+
+	var errs []error
+
+	count := 0
+
+	for elem, err := range parser.Elements() {
+		if err != nil {
+			assert.Nil(t, elem)
+
+			errs = append(errs, err)
+
+			continue
+		}
+
+		count++
+	}
+
+	assert.Equal(t, 1, count)
+	assert.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], xixo.ErrUnquotedAttribute)
+}
+
+func TestStreamContextShouldStopWhenCanceled(t *testing.T) {
+	t.Parallel()
+
+	inputXML := "<root>\n<item>a</item>\n<item>b</item>\n<item>c</item>\n</root>"
+
+	var resultXMLBuffer bytes.Buffer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), &resultXMLBuffer)
+	parser.Register("item", func(ctx context.Context, elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		assert.Nil(t, ctx.Err())
+
+		elem.InnerText = strings.ToUpper(elem.InnerText)
+
+		// a scheduler cancels the job while the first item is processed
+		cancel()
+
+		return elem, nil
+	})
+
+	err := parser.StreamContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var interruptedErr *xixo.InterruptedError
+
+	assert.True(t, errors.As(err, &interruptedErr))
+	assert.Equal(t, 2, interruptedErr.Line)
+	assert.Equal(t, []string{"root"}, interruptedErr.Path)
+
+	assert.Equal(t, "<root>\n<item>A</item>", resultXMLBuffer.String())
+}
+
+func TestStreamContextShouldStopOnDeadline(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	called := false
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(`<root><item>a</item></root>`), io.Discard)
+	parser.Register("item", xixo.XMLElementToJSONCallbackContext(func(ctx context.Context, s string) (string, error) {
+		called = true
+
+		return s, nil
+	}))
+
+	err := parser.StreamContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, called)
+}
+
+func TestConcurrencyShouldKeepDocumentOrder(t *testing.T) {
+	t.Parallel()
+
+	var input, expected strings.Builder
+
+	input.WriteString("<root>\n")
+	expected.WriteString("<root>\n")
+
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&input, "<item id=\"%d\">v%d</item><!-- %d -->\n<self id=\"%d\"/>\n", i, i, i, i)
+		fmt.Fprintf(&expected, "<item id=\"%d\">V%d</item><!-- %d -->\n<self id=\"%d\"/>\n", i, i, i, i)
+	}
+
+	input.WriteString("</root>")
+	expected.WriteString("</root>")
+
+	var (
+		resultXMLBuffer bytes.Buffer
+		running         atomic.Int32
+		maxRunning      atomic.Int32
+	)
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(input.String()), &resultXMLBuffer).Concurrency(4)
+	parser.RegisterMatch("self")
+	parser.RegisterCallback("item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			previous := maxRunning.Load()
+			if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Duration(len(elem.InnerText)%3) * time.Millisecond)
+
+		elem.InnerText = strings.ToUpper(elem.InnerText)
+
+		return elem, nil
+	})
+
+	ids := []string{}
+
+	for elem, err := range parser.Elements() {
+		assert.Nil(t, err)
+
+		ids = append(ids, elem.Name+elem.Attrs["id"].Value)
+	}
+
+	assert.Equal(t, expected.String(), resultXMLBuffer.String())
+	assert.Len(t, ids, 100)
+	assert.Equal(t, []string{"item0", "self0", "item1", "self1"}, ids[:4])
+	assert.Greater(t, maxRunning.Load(), int32(1))
+}
+
+func TestConcurrencyShouldStopOnFirstError(t *testing.T) {
+	t.Parallel()
+
+	errMasking := errors.New("masking engine unavailable")
+	inputXML := "<root>" + strings.Repeat("<item>slow</item>", 10) + "<item>fail</item></root>"
+
+	var completed atomic.Int32
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), io.Discard).Concurrency(16)
+	parser.Register("item", func(ctx context.Context, elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		if elem.InnerText == "fail" {
+			return nil, errMasking
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Second):
+			completed.Add(1)
+
+			return elem, nil
+		}
+	})
+
+	err := parser.Stream()
+	assert.ErrorIs(t, err, errMasking)
+
+	var callbackErr *xixo.CallbackError
+
+	assert.True(t, errors.As(err, &callbackErr))
+	assert.Equal(t, "/root/item", callbackErr.Path)
+	// pending callbacks were canceled and awaited
+	assert.Equal(t, int32(0), completed.Load())
+}
+
+func TestUnmatchedRegionsShouldBeCopiedUnchanged(t *testing.T) {
+	t.Parallel()
+
+	inputXML := "<?xml version=\"1.0\"?>\n<root>\n" +
+		"<other a=\"x > y\" b='/'><!-- </other> --><![CDATA[</other><x>]]><?pi </other> ?>" +
+		"<other><other/>text</other><self /></other>\n" +
+		"<a><b>1</b><c><b>2</b></c></a>\n" +
+		"<other>\n<b>3</b></other>\n" +
+		"</root>\n"
+
+	expected := strings.Replace(inputXML, "<b>1</b>", "<b>ONE</b>", 1)
+
+	for name, reader := range map[string]func() io.Reader{
+		"buffered": func() io.Reader { return bytes.NewBufferString(inputXML) },
+		"one byte": func() io.Reader { return iotest.OneByteReader(bytes.NewBufferString(inputXML)) },
+	} {
+		reader := reader
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultXMLBuffer bytes.Buffer
+
+			parser := xixo.NewXMLParser(reader(), &resultXMLBuffer)
+			parser.RegisterCallback("/root/a/b", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+				elem.InnerText = "ONE"
+
+				return elem, nil
+			})
+
+			err := parser.Stream()
+			assert.Nil(t, err)
+
+			assert.Equal(t, expected, resultXMLBuffer.String())
+		})
+	}
+}
+
+func TestParseErrorShouldBeLocatedAfterCopiedRegions(t *testing.T) {
+	t.Parallel()
+
+	inputXML := "<root>\n<other>\nsome text\n</other>\n<item>\n  <name id=1>x</name></item></root>"
+
+	parser := xixo.NewXMLParser(bytes.NewBufferString(inputXML), io.Discard)
+	parser.RegisterCallback("/root/item", func(elem *xixo.XMLElement) (*xixo.XMLElement, error) {
+		return elem, nil
+	})
+
+	err := parser.Stream()
+	assert.ErrorIs(t, err, xixo.ErrUnquotedAttribute)
+
+	var parseErr *xixo.ParseError
+
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, 6, parseErr.Line)
+	assert.Equal(t, 12, parseErr.Column)
+	assert.Equal(t, uint64(strings.Index(inputXML, "=1")+2), parseErr.Offset)
+}
+
+func TestParseErrorShouldBeLocatedInCopiedRegions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input    string
+		match    string
+		expected error
+		offset   int
+	}{
+		{input: "<root>\n<a x=\"1>", expected: xixo.ErrUnterminatedAttribute, offset: 15},
+		{input: "<root>\n<a x=\"1>", match: "header", expected: xixo.ErrUnterminatedAttribute, offset: 15},
+		{input: "<root>\n<x><![CDOTA[x]]></x></root>", match: "/root/item", expected: xixo.ErrInvalidCDATA, offset: 16},
+		{input: "<root>\n<x><!-x--></x></root>", match: "header", expected: xixo.ErrInvalidComment, offset: 14},
+		{input: "<root>\n<x>text", expected: xixo.ErrUnexpectedEOF, offset: 14},
+	}
+
+	for _, testCase := range tests {
+		parser := xixo.NewXMLParser(bytes.NewBufferString(testCase.input), io.Discard)
+		if testCase.match != "" {
+			parser.RegisterCallback(testCase.match, identity)
+		}
+
+		err := parser.Stream()
+		assert.ErrorIs(t, err, testCase.expected, testCase.input)
+
+		var parseErr *xixo.ParseError
+
+		if assert.True(t, errors.As(err, &parseErr), testCase.input) {
+			assert.Equal(t, 2, parseErr.Line, testCase.input)
+			assert.Equal(t, uint64(testCase.offset), parseErr.Offset, testCase.input)
+		}
+	}
+}
+
+func TestStreamShouldRoundTripMixedContent(t *testing.T) {
+	t.Parallel()
+
+	input := `<doc><p>Hello <b>x</b> world<!-- c --> and <![CDATA[<y>]]> again</p><p>Bye <b>z</b>.</p></doc>`
+	expected := `<doc><p>Hello <b>X</b> world<!-- c --> and <![CDATA[<y>]]> again</p><p>Bye <b>Z</b>.</p></doc>`
+
+	var output bytes.Buffer
+
+	parser := xixo.NewXMLParser(strings.NewReader(input), &output)
+	parser.RegisterCallback("p", func(element *xixo.XMLElement) (*xixo.XMLElement, error) {
+		bold := element.Child("b")
+		bold.InnerText = strings.ToUpper(bold.InnerText)
+
+		return element, nil
+	})
+
+	assert.Nil(t, parser.Stream())
+	assert.Equal(t, expected, output.String())
+}
+
+func TestStreamShouldPreserveCDATASections(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+		asText   bool
+	}{
+		{
+			name:     "unchanged",
+			input:    `<root><code><![CDATA[if (a < b && c) {}]]></code></root>`,
+			expected: `<root><code><![CDATA[if (a < b && c) {}]]></code></root>`,
+		},
+		{
+			name:     "replaced",
+			input:    `<root><code><![CDATA[a < b]]></code></root>`,
+			expected: `<root><code><![CDATA[<A>]]]]><![CDATA[>]]></code></root>`,
+		},
+		{
+			name:     "as text",
+			input:    `<root><code>x <![CDATA[a < b && c]]></code></root>`,
+			expected: `<root><code>x a &lt; b &amp;&amp; c</code></root>`,
+			asText:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			parser := xixo.NewXMLParser(strings.NewReader(tc.input), &output)
+			if tc.asText {
+				parser.CDATAAsText()
+			}
+
+			parser.RegisterCallback("code", func(element *xixo.XMLElement) (*xixo.XMLElement, error) {
+				if tc.name == "replaced" {
+					assert.Equal(t, xixo.CDATANode, element.Nodes()[0].Kind)
+					element.InnerText = "<A>]]>"
+				}
+
+				return element, nil
+			})
+
+			assert.Nil(t, parser.Stream())
+			assert.Equal(t, tc.expected, output.String())
+		})
+	}
+}
+
+func TestStreamShouldKeepUntouchedTagsByteExact(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+		change   func(*xixo.XMLElement)
+	}{
+		{
+			name:     "unchanged",
+			input:    "<root><item  id='a'\n  kind=\"b\" ><empty /><full></full ><x/></item ></root>",
+			expected: "<root><item  id='a'\n  kind=\"b\" ><empty /><full></full ><x/></item ></root>",
+			change:   func(*xixo.XMLElement) {},
+		},
+		{
+			name:     "changed attribute",
+			input:    "<root><item  id='a' ><empty /><name >x</name ></item ></root>",
+			expected: "<root><item  id='b' ><empty /><name >x</name ></item ></root>",
+			change: func(element *xixo.XMLElement) {
+				element.AddAttribute(xixo.Attribute{Name: "id", Value: "b"})
+			},
+		},
+		{
+			name:     "untouched attributes",
+			input:    "<root><item a = \"&#65;\"\n   b='x' c=\"&co;\" ><name>x</name></item></root>",
+			expected: "<root><item a = \"&#65;\"\n   b='y' c=\"&co;\" d=\"&#9;\" ><name>x</name></item></root>",
+			change: func(element *xixo.XMLElement) {
+				element.AddAttribute(xixo.Attribute{Name: "b", Value: "y"})
+				element.AddAttribute(xixo.Attribute{Name: "d", Value: "\t"})
+			},
+		},
+		{
+			name:     "changed child",
+			input:    "<root><item  id='a' ><empty /><name >x</name ></item ></root>",
+			expected: "<root><item  id='a' ><empty>y</empty><renamed >x</renamed></item ></root>",
+			change: func(element *xixo.XMLElement) {
+				element.Child("empty").InnerText = "y"
+				element.Child("name").Name = "renamed"
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			parser := xixo.NewXMLParser(strings.NewReader(tc.input), &output)
+			parser.RegisterCallback("item", func(element *xixo.XMLElement) (*xixo.XMLElement, error) {
+				tc.change(element)
+
+				return element, nil
+			})
+
+			assert.Nil(t, parser.Stream())
+			assert.Equal(t, tc.expected, output.String())
+		})
+	}
+}
+
+func TestNodesCallbackShouldReplaceMatchedElements(t *testing.T) {
+	t.Parallel()
+
+	const input = "<root>\n  <rec id=\"1\">a</rec>\n  <rec id=\"2\">b c</rec>\n  <rec id=\"3\"/>\n</root>"
+
+	testCases := []struct {
+		name     string
+		expected string
+		callback xixo.CallbackNodes
+	}{
+		{
+			name:     "drop",
+			expected: "<root>\n  <rec id=\"1\">a</rec>\n</root>",
+			callback: func(element *xixo.XMLElement) ([]*xixo.Node, error) {
+				if element.Attrs["id"].Value != "1" {
+					return nil, nil
+				}
+
+				return []*xixo.Node{{Kind: xixo.ElementNode, Element: element}}, nil
+			},
+		},
+		{
+			name: "split",
+			expected: "<root>\n  <rec id=\"1\">a</rec>\n  <rec id=\"2\">b</rec>\n  <rec id=\"2\">c</rec>\n" +
+				"  <rec id=\"3\"/>\n</root>",
+			callback: func(element *xixo.XMLElement) ([]*xixo.Node, error) {
+				nodes := []*xixo.Node{}
+
+				for _, text := range strings.Fields(element.InnerText) {
+					split := xixo.NewXMLElement()
+					split.Name = element.Name
+					split.AddAttribute(xixo.Attribute{Name: "id", Value: element.Attrs["id"].Value})
+					split.InnerText = text
+
+					nodes = append(nodes, &xixo.Node{Kind: xixo.ElementNode, Element: split})
+				}
+
+				if len(nodes) == 0 {
+					nodes = append(nodes, &xixo.Node{Kind: xixo.ElementNode, Element: element})
+				}
+
+				return nodes, nil
+			},
+		},
+		{
+			name:     "comment",
+			expected: "<root>\n  <!-- rec 1 -->\n  <!-- rec 2 -->\n  <!-- rec 3 -->\n</root>",
+			callback: func(element *xixo.XMLElement) ([]*xixo.Node, error) {
+				return []*xixo.Node{{Kind: xixo.CommentNode, Data: " rec " + element.Attrs["id"].Value + " "}}, nil
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s with %d workers", tc.name, workers), func(t *testing.T) {
+				t.Parallel()
+
+				var output bytes.Buffer
+
+				parser := xixo.NewXMLParser(strings.NewReader(input), &output).Concurrency(workers)
+				parser.RegisterNodesCallback("rec", tc.callback)
+
+				assert.Nil(t, parser.Stream())
+				assert.Equal(t, tc.expected, output.String())
+			})
+
+			t.Run(fmt.Sprintf("%s nested with %d workers", tc.name, workers), func(t *testing.T) {
+				t.Parallel()
+
+				var output bytes.Buffer
+
+				parser := xixo.NewXMLParser(strings.NewReader(input), &output).Concurrency(workers)
+				parser.RegisterNodesCallback("rec", tc.callback)
+				parser.RegisterCallback("root", func(element *xixo.XMLElement) (*xixo.XMLElement, error) {
+					return element, nil
+				})
+
+				assert.Nil(t, parser.Stream())
+				assert.Equal(t, tc.expected, output.String())
+			})
+		}
+	}
+}
+
+func TestCallbacksShouldDropElements(t *testing.T) {
+	t.Parallel()
+
+	input := "<root>\n  <rec id=\"1\"/>\n  <rec id=\"2\"/>\n  <p>text <rec id=\"3\"/> and <b>bold</b></p>\n</root>"
+	expected := "<root>\n  <rec id=\"1\"/>\n  <p>text  and <b>bold</b></p>\n</root>"
+
+	var output bytes.Buffer
+
+	parser := xixo.NewXMLParser(strings.NewReader(input), &output)
+	parser.RegisterMapCallback("rec", func(dict map[string]string) (map[string]string, error) {
+		if dict["@id"] != "1" {
+			return nil, fmt.Errorf("record %s: %w", dict["@id"], xixo.ErrDropElement)
+		}
+
+		return dict, nil
+	})
+
+	var matched []string
+
+	for element, err := range parser.Elements() {
+		assert.Nil(t, err)
+
+		matched = append(matched, element.Attrs["id"].Value)
+	}
+
+	assert.Equal(t, []string{"1"}, matched)
+	assert.Equal(t, expected, output.String())
+}
+
+func TestCallbackReturningNilShouldDropElement(t *testing.T) {
+	t.Parallel()
+
+	for _, workers := range []int{1, 2} {
+		var output bytes.Buffer
+
+		parser := xixo.NewXMLParser(strings.NewReader("<root>\n  <r>1</r>\n</root>"), &output).Concurrency(workers)
+		parser.RegisterCallback("r", func(*xixo.XMLElement) (*xixo.XMLElement, error) {
+			return nil, nil //nolint:nilnil
+		})
+
+		assert.Nil(t, parser.Stream())
+		assert.Equal(t, "<root>\n</root>", output.String(), workers)
+	}
+}
+
+func TestDropShouldKeepIndentationSharedWithNextSibling(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"next sibling on the same line", "<r>\n  <a/><b/>\n</r>", "<r>\n  <b/>\n</r>"},
+		{"followed by a line break", "<r>\n  <a/>  \n  <b/>\n</r>", "<r>  \n  <b/>\n</r>"},
+		{"followed by the parent end tag", "<r>\n  <b/>\n  <a>x</a></r>", "<r>\n  <b/></r>"},
+	}
+
+	for _, tc := range testCases {
+		for _, workers := range []int{1, 4} {
+			for _, nested := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s with %d workers nested %t", tc.name, workers, nested), func(t *testing.T) {
+					t.Parallel()
+
+					var output bytes.Buffer
+
+					parser := xixo.NewXMLParser(strings.NewReader(tc.input), &output).Concurrency(workers)
+					parser.RegisterCallback("a", func(*xixo.XMLElement) (*xixo.XMLElement, error) {
+						return nil, xixo.ErrDropElement
+					})
+
+					if nested {
+						parser.RegisterMatch("r")
+					}
+
+					assert.Nil(t, parser.Stream())
+					assert.Equal(t, tc.expected, output.String())
+				})
+			}
+		}
+	}
+}
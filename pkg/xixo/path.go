@@ -0,0 +1,176 @@
+package xixo
+
+import "strings"
+
+// pathStep is one step of a path expression.
+type pathStep struct {
+	// name is a qualified name, a {uri}local expanded name or the * wildcard.
+	name string
+	// descendant is true when the step is preceded by //, any number of elements
+	// may then stand between this step and the previous one.
+	descendant bool
+}
+
+// matches reports whether the step selects the element.
+func (s pathStep) matches(element *XMLElement) bool {
+	switch {
+	case s.name == "*":
+		return true
+	case strings.HasPrefix(s.name, "{"):
+		return s.name == "{"+element.namespaceURI+"}"+element.LocalName() ||
+			(element.namespaceURI == "" && s.name == "{}"+element.LocalName())
+	default:
+		return s.name == element.Name
+	}
+}
+
+// matchesAny reports whether the step may select an element with one of the qualified names, the namespace
+// of a {uri}local step being unknown from the name alone.
+func (s pathStep) matchesAny(names map[string]bool) bool {
+	if s.name == "*" {
+		return len(names) > 0
+	}
+
+	if !strings.HasPrefix(s.name, "{") {
+		return names[s.name]
+	}
+
+	local := s.name[strings.Index(s.name, "}")+1:]
+
+	for name := range names {
+		if name == local || strings.HasSuffix(name, ":"+local) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathPattern is a compiled path expression selecting elements by their ancestors:
+//
+//	/root/customer/address  absolute path from the document element
+//	customer/address        address elements whose parent is a customer, at any depth
+//	//address               address elements at any depth, same as address
+//	/root//address          address elements at any depth below root
+type pathPattern struct {
+	steps []pathStep
+}
+
+// compilePath parses a path expression, relative paths being anchored at any depth.
+func compilePath(expr string) pathPattern {
+	pattern := pathPattern{}
+	descendant := !strings.HasPrefix(expr, "/")
+
+	for _, token := range splitPath(expr) {
+		if token == "" {
+			// an empty token comes from a double slash
+			descendant = true
+
+			continue
+		}
+
+		pattern.steps = append(pattern.steps, pathStep{name: token, descendant: descendant})
+		descendant = false
+	}
+
+	return pattern
+}
+
+// splitPath splits a path expression on slashes, except those inside {uri} braces.
+func splitPath(expr string) []string {
+	tokens := []string{}
+	depth := 0
+	start := 0
+
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				if i > 0 {
+					tokens = append(tokens, expr[start:i])
+				}
+
+				start = i + 1
+			}
+		}
+	}
+
+	return append(tokens, expr[start:])
+}
+
+// match reports whether the pattern selects the last element of path,
+// path holding every open element from the document element down.
+func (p pathPattern) match(path []*XMLElement) bool {
+	if len(p.steps) == 0 || len(path) == 0 {
+		return false
+	}
+
+	return p.matchFrom(len(p.steps)-1, path, len(path)-1)
+}
+
+func (p pathPattern) matchFrom(step int, path []*XMLElement, index int) bool {
+	if !p.steps[step].matches(path[index]) {
+		return false
+	}
+
+	if step == 0 {
+		return p.steps[0].descendant || index == 0
+	}
+
+	if !p.steps[step].descendant {
+		return index > 0 && p.matchFrom(step-1, path, index-1)
+	}
+
+	for ancestor := index - 1; ancestor >= 0; ancestor-- {
+		if p.matchFrom(step-1, path, ancestor) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canMatchBelow reports whether the pattern may select a descendant of the last element of path,
+// below reporting whether a step may select an element of the subtree.
+func (p pathPattern) canMatchBelow(path []*XMLElement, below func(pathStep) bool) bool {
+	if len(p.steps) == 0 {
+		return false
+	}
+
+	if (p.steps[0].descendant || len(path) == 0) && p.stepsBelow(0, below) {
+		return true
+	}
+
+	// step k is the first one left to descendants, the previous ones matching ancestors in path
+	for k := 1; k < len(p.steps); k++ {
+		for end := 1; end <= len(path); end++ {
+			if (end == len(path) || p.steps[k].descendant) && p.matchPrefix(k, path[:end]) && p.stepsBelow(k, below) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// stepsBelow reports whether every step from k may select an element of the subtree.
+func (p pathPattern) stepsBelow(k int, below func(pathStep) bool) bool {
+	for _, step := range p.steps[k:] {
+		if !below(step) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchPrefix reports whether the first k steps select the last element of path.
+func (p pathPattern) matchPrefix(k int, path []*XMLElement) bool {
+	prefix := pathPattern{steps: p.steps[:k]}
+
+	return prefix.match(path)
+}
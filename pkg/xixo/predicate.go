@@ -0,0 +1,91 @@
+package xixo
+
+// Predicate decides whether a callback applies to an element matched by its path expression.
+// It is evaluated once the element tree is built; elements it rejects are written unchanged.
+type Predicate func(*XMLElement) bool
+
+// AttrEquals selects the elements having the attribute name set to value.
+func AttrEquals(name, value string) Predicate {
+	return func(element *XMLElement) bool {
+		attr, ok := element.Attrs[name]
+
+		return ok && attr.Value == value
+	}
+}
+
+// HasAttr selects the elements having the attribute name, whatever its value.
+func HasAttr(name string) Predicate {
+	return func(element *XMLElement) bool {
+		_, ok := element.Attrs[name]
+
+		return ok
+	}
+}
+
+// ChildTextEquals selects the elements having a child named name whose inner text is value.
+func ChildTextEquals(name, value string) Predicate {
+	return func(element *XMLElement) bool {
+		for _, child := range element.ChildrenNamed(name) {
+			if child.InnerText == value {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AtDepth selects the elements at the given depth in the document, the document element being at depth 1.
+func AtDepth(depth int) Predicate {
+	return func(element *XMLElement) bool {
+		return element.Depth() == depth
+	}
+}
+
+// XPathPredicate selects the elements for which the XPath expression evaluates to true,
+// such as @role = 'customer' or count(phone) > 1. Evaluation errors reject the element.
+func XPathPredicate(expr string) (Predicate, error) {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(element *XMLElement) bool {
+		result, err := xpath.EvaluateBoolean(element)
+
+		return err == nil && result
+	}, nil
+}
+
+// Not negates a predicate.
+func Not(predicate Predicate) Predicate {
+	return func(element *XMLElement) bool {
+		return !predicate(element)
+	}
+}
+
+// And selects the elements satisfying all predicates.
+func And(predicates ...Predicate) Predicate {
+	return func(element *XMLElement) bool {
+		for _, predicate := range predicates {
+			if !predicate(element) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Or selects the elements satisfying at least one of the predicates.
+func Or(predicates ...Predicate) Predicate {
+	return func(element *XMLElement) bool {
+		for _, predicate := range predicates {
+			if predicate(element) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
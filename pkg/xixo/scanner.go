@@ -0,0 +1,435 @@
+package xixo
+
+import (
+	"bytes"
+)
+
+// scanState is the kind of markup the subtree scanner is in.
+type scanState int
+
+const (
+	scanText scanState = iota
+	scanOpen
+	scanBang
+	scanBangDash
+	scanCDATAMarker
+	scanStartTag
+	scanEndTag
+	scanComment
+	scanCDATA
+	scanInstruction
+	scanDeclaration
+)
+
+// subtreeScanner finds the end of an element whose content is copied without being parsed,
+// only tracking the nesting of elements. It is fed with successive chunks of the input.
+type subtreeScanner struct {
+	depth int
+	state scanState
+	quote byte
+	// last holds the two bytes preceding the current chunk, to recognize terminators across chunks
+	last [2]byte
+	// names collects the names of the start tags when not nil
+	names map[string]bool
+	// marker counts the bytes of the CDATA section marker read so far
+	marker int
+	// err is the malformed markup the scan stopped on
+	err error
+}
+
+// scan consumes data and returns how many bytes were consumed, and true when the element is closed.
+// On malformed markup, it stops after the offending byte and sets err.
+func (s *subtreeScanner) scan(data []byte) (int, bool) {
+	pos := 0
+
+	for pos < len(data) {
+		switch s.state {
+		case scanText:
+			next := bytes.IndexByte(data[pos:], '<')
+			if next < 0 {
+				pos = len(data)
+
+				continue
+			}
+
+			pos += next + 1
+			s.state = scanOpen
+		case scanOpen:
+			switch data[pos] {
+			case '/':
+				s.state = scanEndTag
+			case '!':
+				s.state = scanBang
+			case '?':
+				s.state = scanInstruction
+			default:
+				s.state = scanStartTag
+				s.collectName(data[pos:])
+			}
+
+			pos++
+		case scanBang:
+			switch data[pos] {
+			case '-':
+				s.state = scanBangDash
+			case '[':
+				s.state = scanCDATAMarker
+				s.marker = len("<![")
+			default:
+				s.state = scanDeclaration
+			}
+
+			pos++
+		case scanBangDash:
+			if data[pos] != '-' {
+				s.err = ErrInvalidComment
+
+				return pos + 1, false
+			}
+
+			s.state = scanComment
+			pos++
+		case scanCDATAMarker:
+			if data[pos] != cdataStart[s.marker] {
+				s.err = ErrInvalidCDATA
+
+				return pos + 1, false
+			}
+
+			s.marker++
+			if s.marker == len(cdataStart) {
+				s.state = scanCDATA
+			}
+
+			pos++
+		case scanStartTag:
+			pos = s.scanStartTag(data, pos)
+		case scanEndTag, scanDeclaration:
+			next := bytes.IndexByte(data[pos:], '>')
+			if next < 0 {
+				pos = len(data)
+
+				continue
+			}
+
+			pos += next + 1
+
+			if s.state == scanEndTag {
+				s.depth--
+			}
+
+			s.state = scanText
+
+			if s.depth == 0 {
+				s.keepLast(data[:pos])
+
+				return pos, true
+			}
+		case scanComment, scanCDATA, scanInstruction:
+			pos = s.scanTerminator(data, pos)
+		}
+	}
+
+	s.keepLast(data)
+
+	return pos, false
+}
+
+// eofReason returns the error reported when the input ends before the element is closed.
+func (s *subtreeScanner) eofReason() error {
+	if s.state == scanStartTag && s.quote != 0 {
+		return ErrUnterminatedAttribute
+	}
+
+	return ErrUnexpectedEOF
+}
+
+// collectName records the name of the start tag beginning data.
+func (s *subtreeScanner) collectName(data []byte) {
+	if s.names == nil {
+		return
+	}
+
+	if end := bytes.IndexAny(data, " \t\r\n/>"); end > 0 {
+		s.names[string(data[:end])] = true
+	}
+}
+
+// scanStartTag consumes a start tag up to its '>', skipping quoted attribute values.
+func (s *subtreeScanner) scanStartTag(data []byte, pos int) int {
+	for pos < len(data) {
+		if s.quote != 0 {
+			next := bytes.IndexByte(data[pos:], s.quote)
+			if next < 0 {
+				return len(data)
+			}
+
+			pos += next + 1
+			s.quote = 0
+
+			continue
+		}
+
+		next := bytes.IndexAny(data[pos:], "\"'>")
+		if next < 0 {
+			return len(data)
+		}
+
+		pos += next
+
+		if data[pos] != '>' {
+			s.quote = data[pos]
+			pos++
+
+			continue
+		}
+
+		if s.preceding(data, pos, 1) != '/' {
+			s.depth++
+		}
+
+		s.state = scanText
+
+		return pos + 1
+	}
+
+	return pos
+}
+
+// scanTerminator consumes a comment, a CDATA section or a processing instruction up to its terminator.
+func (s *subtreeScanner) scanTerminator(data []byte, pos int) int {
+	for pos < len(data) {
+		next := bytes.IndexByte(data[pos:], '>')
+		if next < 0 {
+			return len(data)
+		}
+
+		pos += next
+
+		var ended bool
+
+		switch s.state {
+		case scanComment:
+			ended = s.preceding(data, pos, 1) == '-' && s.preceding(data, pos, 2) == '-'
+		case scanCDATA:
+			ended = s.preceding(data, pos, 1) == ']' && s.preceding(data, pos, 2) == ']'
+		default:
+			ended = s.preceding(data, pos, 1) == '?'
+		}
+
+		pos++
+
+		if ended {
+			s.state = scanText
+
+			return pos
+		}
+	}
+
+	return pos
+}
+
+// preceding returns the byte found n bytes before pos, possibly in the previous chunks.
+func (s *subtreeScanner) preceding(data []byte, pos int, n int) byte {
+	if pos >= n {
+		return data[pos-n]
+	}
+
+	return s.last[len(s.last)-(n-pos)]
+}
+
+// keepLast records the last bytes of a consumed chunk.
+func (s *subtreeScanner) keepLast(data []byte) {
+	switch len(data) {
+	case 0:
+	case 1:
+		s.last[0], s.last[1] = s.last[1], data[0]
+	default:
+		s.last[0], s.last[1] = data[len(data)-2], data[len(data)-1]
+	}
+}
+
+// canCopySubtree reports whether the content of the innermost open element may be copied without
+// being parsed: no callback may select an element below it and no element must be removed.
+func (x *XMLParser) canCopySubtree() bool {
+	if x.strict || (x.skipOuterElements && len(x.skipElements) > 0) {
+		return false
+	}
+
+	var (
+		names   map[string]bool
+		scanned bool
+		known   bool
+	)
+
+	// below reports whether an element of the subtree may be selected by the step, the names of the subtree
+	// being scanned ahead once in the reader buffer
+	below := func(step pathStep) bool {
+		if !scanned {
+			names, known = x.subtreeNames()
+			scanned = true
+		}
+
+		return !known || step.matchesAny(names)
+	}
+
+	for _, loop := range x.loopElements {
+		if loop.pattern.canMatchBelow(x.path, below) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// subtreeNames returns the names of the elements below the innermost open element, scanned ahead in the
+// bytes already buffered, and false when its close tag is not among them. The reader is not filled, not to
+// wait for input that may not be needed yet.
+func (x *XMLParser) subtreeNames() (map[string]bool, bool) {
+	data, _ := x.reader.Peek(x.reader.Buffered())
+	scanner := subtreeScanner{depth: 1, names: map[string]bool{}}
+
+	_, closed := scanner.scan(data)
+
+	return scanner.names, closed
+}
+
+// copySubtree copies the content of the innermost open element up to its close tag, included.
+func (x *XMLParser) copySubtree() error {
+	scanner := subtreeScanner{depth: 1}
+
+	for {
+		data, err := x.buffered()
+		if err != nil {
+			return x.eofError(err, scanner.eofReason())
+		}
+
+		n, closed := scanner.scan(data)
+
+		err = x.passthrough(data[:n])
+		if err != nil {
+			return err
+		}
+
+		if scanner.err != nil {
+			return x.parseError(scanner.err)
+		}
+
+		if closed {
+			x.popElement()
+
+			return nil
+		}
+	}
+}
+
+// copyText copies the character data up to the next markup.
+func (x *XMLParser) copyText() error {
+	for {
+		data, err := x.buffered()
+		if err != nil {
+			// the end of input is handled by the byte by byte parser
+			return nil
+		}
+
+		n := bytes.IndexByte(data, '<')
+		if n == 0 {
+			return x.checkTextEnd()
+		}
+
+		if n < 0 {
+			n = len(data)
+		}
+
+		if err = x.checkChunk(data[:n]); err != nil {
+			return err
+		}
+
+		err = x.passthrough(data[:n])
+		if err != nil {
+			return err
+		}
+
+		if n < len(data) {
+			return x.checkTextEnd()
+		}
+	}
+}
+
+// checkChunk validates in strict mode a chunk of character data. The chunk is copied up to the
+// invalid byte, for the error to be located on it.
+func (x *XMLParser) checkChunk(chunk []byte) error {
+	if !x.strict {
+		return nil
+	}
+
+	for i, c := range chunk {
+		if x.text.feed(c) {
+			continue
+		}
+
+		if err := x.passthrough(chunk[:i+1]); err != nil {
+			return err
+		}
+
+		if c == '>' {
+			return x.parseError(ErrCDATAEndInText)
+		}
+
+		return x.parseError(ErrInvalidReference)
+	}
+
+	return nil
+}
+
+// buffered returns the bytes available in the reader buffer, filling it when empty.
+func (x *XMLParser) buffered() ([]byte, error) {
+	_, err := x.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.reader.Peek(x.reader.Buffered())
+}
+
+// passthrough consumes the chunk at the head of the reader buffer, copying it to the output.
+func (x *XMLParser) passthrough(chunk []byte) error {
+	if x.strict {
+		for _, c := range chunk {
+			if err := x.checkChar(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	if x.nextWrite != nil {
+		err := x.writer.WriteByte(*x.nextWrite)
+		if err != nil {
+			return err
+		}
+
+		x.nextWrite = nil
+	}
+
+	_, err := x.writer.Write(chunk)
+	if err != nil {
+		return err
+	}
+
+	x.TotalReadSize += uint64(len(chunk))
+
+	if len(chunk) > 0 {
+		x.lastByte = chunk[len(chunk)-1]
+	}
+
+	if lines := bytes.Count(chunk, []byte{'\n'}); lines > 0 {
+		x.line += lines
+		x.column = len(chunk) - bytes.LastIndexByte(chunk, '\n') - 1
+	} else {
+		x.column += len(chunk)
+	}
+
+	_, err = x.reader.Discard(len(chunk))
+
+	return err
+}
@@ -0,0 +1,392 @@
+package xixo
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// byteOrderMark is the UTF-8 encoded U+FEFF allowed before the document.
+const byteOrderMark = "\xEF\xBB\xBF"
+
+// isXMLChar reports whether r is a legal character of an XML 1.0 document.
+func isXMLChar(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return true
+	case r >= 0x20 && r <= 0xD7FF:
+		return true
+	case r >= 0xE000 && r <= 0xFFFD:
+		return true
+	default:
+		return r >= 0x10000 && r <= utf8.MaxRune
+	}
+}
+
+// isXMLNameStartChar reports whether r may start an XML 1.0 name.
+func isXMLNameStartChar(r rune) bool {
+	switch {
+	case r == ':' || r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z'):
+		return true
+	case r >= 0xC0 && r <= 0xD6, r >= 0xD8 && r <= 0xF6, r >= 0xF8 && r <= 0x2FF:
+		return true
+	case r >= 0x370 && r <= 0x37D, r >= 0x37F && r <= 0x1FFF, r >= 0x200C && r <= 0x200D:
+		return true
+	case r >= 0x2070 && r <= 0x218F, r >= 0x2C00 && r <= 0x2FEF, r >= 0x3001 && r <= 0xD7FF:
+		return true
+	case r >= 0xF900 && r <= 0xFDCF, r >= 0xFDF0 && r <= 0xFFFD, r >= 0x10000 && r <= 0xEFFFF:
+		return true
+	default:
+		return false
+	}
+}
+
+// isXMLNameChar reports whether r may appear in an XML 1.0 name after its first character.
+func isXMLNameChar(r rune) bool {
+	switch {
+	case isXMLNameStartChar(r):
+		return true
+	case r == '-' || r == '.' || (r >= '0' && r <= '9') || r == 0xB7:
+		return true
+	default:
+		return (r >= 0x300 && r <= 0x36F) || (r >= 0x203F && r <= 0x2040)
+	}
+}
+
+// isXMLName reports whether s matches the Name production of XML 1.0.
+func isXMLName(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+
+	for i, r := range s {
+		if i == 0 && !isXMLNameStartChar(r) || !isXMLNameChar(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// charDecoder validates a byte stream as UTF-8 encoded XML characters, one byte at a time.
+type charDecoder struct {
+	// r is the rune being decoded, need the number of continuation bytes still expected
+	// and min the smallest rune allowed for the sequence length, rejecting overlong forms.
+	r    rune
+	need int
+	min  rune
+}
+
+// feed decodes the next byte and reports whether the input is still legal.
+func (d *charDecoder) feed(b byte) bool {
+	switch {
+	case b < utf8.RuneSelf:
+		return d.need == 0 && isXMLChar(rune(b))
+	case b < 0xC0:
+		if d.need == 0 {
+			return false
+		}
+
+		d.r = d.r<<6 | rune(b&0x3F)
+		d.need--
+
+		return d.need > 0 || (d.r >= d.min && isXMLChar(d.r))
+	case d.need > 0:
+		return false
+	case b < 0xE0:
+		d.r, d.need, d.min = rune(b&0x1F), 1, 0x80
+	case b < 0xF0:
+		d.r, d.need, d.min = rune(b&0x0F), 2, 0x800
+	case b < 0xF8:
+		d.r, d.need, d.min = rune(b&0x07), 3, 0x10000
+	default:
+		return false
+	}
+
+	return true
+}
+
+// entityDecls are the general entities declared by the internal subset of the document type declaration.
+// Without an internal subset, only the predefined entities may be referenced.
+type entityDecls struct {
+	names map[string]bool
+	// any is set when the subset references parameter entities, which may declare any entity
+	any bool
+}
+
+// parseEntityDecls collects the general entities declared by the document type declaration doctype.
+func parseEntityDecls(doctype []byte) *entityDecls {
+	decls := &entityDecls{names: map[string]bool{}}
+
+	start := bytes.IndexByte(doctype, '[')
+	if start < 0 {
+		return decls
+	}
+
+	subset := doctype[start+1:]
+
+	for rest := subset; ; {
+		next := bytes.Index(rest, []byte("<!ENTITY"))
+		if next < 0 {
+			break
+		}
+
+		rest = bytes.TrimLeft(rest[next+len("<!ENTITY"):], " \t\r\n")
+		if end := bytes.IndexAny(rest, " \t\r\n"); end > 0 && rest[0] != '%' {
+			decls.names[string(rest[:end])] = true
+		}
+	}
+
+	for i, c := range subset {
+		if c != '%' {
+			continue
+		}
+
+		if end := bytes.IndexByte(subset[i+1:], ';'); end > 0 && isXMLName(string(subset[i+1:i+1+end])) {
+			decls.any = true
+		}
+	}
+
+	return decls
+}
+
+// declared reports whether name, an entity reference without '&' and ';', may be referenced.
+func (decls *entityDecls) declared(name string) bool {
+	if _, ok := predefinedEntities[name]; ok {
+		return true
+	}
+
+	return decls != nil && (decls.any || decls.names[name])
+}
+
+// textChecker validates character data one byte at a time: references must be well-formed, entities
+// declared, and the sequence ]]> must not appear.
+type textChecker struct {
+	entities *entityDecls
+	// reference holds the reference being read, without its '&'
+	reference []byte
+	inRef     bool
+	// brackets counts the ']' read last, up to 2
+	brackets int
+}
+
+// feed checks the next byte of character data.
+func (t *textChecker) feed(b byte) bool {
+	if t.inRef {
+		if b == ';' {
+			t.inRef = false
+
+			return isReference(t.reference) && (t.reference[0] == '#' || t.entities.declared(string(t.reference)))
+		}
+
+		t.reference = append(t.reference, b)
+
+		return b != '&' && b != '<' && !isWS(b)
+	}
+
+	switch b {
+	case '&':
+		t.inRef = true
+		t.reference = t.reference[:0]
+		t.brackets = 0
+	case ']':
+		t.brackets = min(t.brackets+1, 2)
+	case '>':
+		if t.brackets == 2 {
+			return false
+		}
+
+		t.brackets = 0
+	default:
+		t.brackets = 0
+	}
+
+	return true
+}
+
+// end reports whether the character data may end, no reference being left open.
+func (t *textChecker) end() bool {
+	open := t.inRef
+	t.inRef = false
+	t.brackets = 0
+
+	return !open
+}
+
+// isReference reports whether name, read between '&' and ';', is an entity or character reference.
+func isReference(name []byte) bool {
+	digits, base := name, "0123456789"
+
+	switch {
+	case bytes.HasPrefix(name, []byte("#x")):
+		digits, base = name[2:], "0123456789abcdefABCDEF"
+	case bytes.HasPrefix(name, []byte("#")):
+		digits = name[1:]
+	default:
+		return isXMLName(string(name))
+	}
+
+	if len(digits) == 0 {
+		return false
+	}
+
+	for _, c := range digits {
+		if strings.IndexByte(base, c) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasValidReferences reports whether the references of s, character data or an attribute value,
+// are well-formed and refer to declared entities.
+func hasValidReferences(s string, entities *entityDecls) bool {
+	checker := textChecker{entities: entities}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '>' {
+			// ]]> is allowed in attribute values
+			checker.brackets = 0
+		}
+
+		if !checker.feed(s[i]) {
+			return false
+		}
+	}
+
+	return checker.end()
+}
+
+// checkText validates in strict mode a byte of character data.
+func (x *XMLParser) checkText(b byte) error {
+	if !x.strict {
+		return nil
+	}
+
+	if !x.text.feed(b) {
+		if b == '>' {
+			return x.parseError(ErrCDATAEndInText)
+		}
+
+		return x.parseError(ErrInvalidReference)
+	}
+
+	return nil
+}
+
+// checkTextEnd validates in strict mode that the character data read so far ends without an open reference.
+func (x *XMLParser) checkTextEnd() error {
+	if x.strict && !x.text.end() {
+		return x.parseError(ErrInvalidReference)
+	}
+
+	return nil
+}
+
+// checkComment validates in strict mode the byte c following the comment body read so far:
+// "--" may only be followed by the closing '>'.
+func (x *XMLParser) checkComment(body []byte, c byte) error {
+	if x.strict && c != '>' && bytes.HasSuffix(body, []byte("--")) {
+		return x.parseError(ErrInvalidComment)
+	}
+
+	return nil
+}
+
+// checkProcInst validates in strict mode a processing instruction, whose target must be a name
+// other than xml.
+func (x *XMLParser) checkProcInst(instruction string) error {
+	if !x.strict {
+		return nil
+	}
+
+	target := instruction
+	if end := strings.IndexAny(instruction, " \t\r\n"); end >= 0 {
+		target = instruction[:end]
+	}
+
+	if !isXMLName(target) || strings.EqualFold(target, "xml") {
+		return x.parseError(ErrInvalidName)
+	}
+
+	return nil
+}
+
+// checkChar validates a byte read from the input in strict mode.
+func (x *XMLParser) checkChar(b byte) error {
+	x.prevDecoder = x.decoder
+
+	if !x.decoder.feed(b) {
+		return x.parseError(ErrInvalidChar)
+	}
+
+	return nil
+}
+
+// checkName validates an element or attribute name in strict mode.
+func (x *XMLParser) checkName(name string) error {
+	if x.strict && !isXMLName(name) {
+		return x.parseError(ErrInvalidName)
+	}
+
+	return nil
+}
+
+// checkAttribute validates an attribute of element in strict mode.
+func (x *XMLParser) checkAttribute(element *XMLElement, name string, value string) error {
+	if !x.strict {
+		return nil
+	}
+
+	if err := x.checkName(name); err != nil {
+		return err
+	}
+
+	if _, ok := element.Attrs[name]; ok {
+		return x.parseError(ErrDuplicateAttribute)
+	}
+
+	if strings.IndexByte(value, '<') >= 0 {
+		return x.parseError(ErrInvalidChar)
+	}
+
+	if !hasValidReferences(value, x.text.entities) {
+		return x.parseError(ErrInvalidReference)
+	}
+
+	return nil
+}
+
+// checkCloseTag validates in strict mode that the close tag name ends the innermost open element.
+func (x *XMLParser) checkCloseTag(name string) error {
+	if x.strict && (len(x.path) == 0 || x.path[len(x.path)-1].Name != name) {
+		return x.parseError(ErrMismatchedTag)
+	}
+
+	return nil
+}
+
+// checkOutsideRoot validates in strict mode a byte read outside of the document element,
+// where only whitespace and markup are allowed.
+func (x *XMLParser) checkOutsideRoot(b byte) error {
+	if !x.strict || isWS(b) {
+		return nil
+	}
+
+	// the byte order mark may start the input
+	if x.TotalReadSize <= uint64(len(byteOrderMark)) && b == byteOrderMark[x.TotalReadSize-1] {
+		return nil
+	}
+
+	return x.parseError(ErrContentOutsideRoot)
+}
+
+// checkEndOfDocument validates in strict mode that the input ended after a complete document element.
+func (x *XMLParser) checkEndOfDocument() error {
+	if x.strict && (len(x.path) > 0 || !x.rootSeen) {
+		return x.parseError(ErrUnexpectedEOF)
+	}
+
+	return nil
+}
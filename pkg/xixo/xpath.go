@@ -0,0 +1,182 @@
+package xixo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrXPathSyntax is returned when an XPath expression can not be compiled.
+var ErrXPathSyntax = errors.New("invalid xpath expression")
+
+// ErrXPathType is returned when an XPath expression evaluates to an unexpected type.
+var ErrXPathType = errors.New("invalid xpath type")
+
+// XPath is a compiled XPath 1.0 expression. The supported subset covers:
+//
+//   - location paths with every axis but namespace, which fails to compile, and their
+//     abbreviations (//, ., .., @);
+//   - name tests (name, prefix:name, {uri}local, *, prefix:*) and the node() and text() tests;
+//   - predicates, either positional ([2], [last()]) or boolean ([@role='customer']);
+//   - unions, comparison, boolean and arithmetic operators;
+//   - the core string, number, boolean and node-set functions.
+//
+// The absolute path / designates the parent of the topmost element of the tree,
+// so that /customer/name selects from a callback on customer elements.
+type XPath struct {
+	expr string
+	root xpathExpr
+}
+
+// CompileXPath parses an XPath expression to evaluate it later on any element.
+func CompileXPath(expr string) (*XPath, error) {
+	parser := &xpathParser{lexer: xpathLexer{input: expr}}
+
+	if err := parser.lexer.tokenize(); err != nil {
+		return nil, err
+	}
+
+	root, err := parser.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !parser.at(xpathEOF) {
+		return nil, parser.errorf("unexpected %q", parser.peek().value)
+	}
+
+	return &XPath{expr: expr, root: root}, nil
+}
+
+// MustCompileXPath is like CompileXPath but panics if the expression can not be compiled.
+func MustCompileXPath(expr string) *XPath {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return xpath
+}
+
+// String returns the source of the expression.
+func (xp *XPath) String() string {
+	return xp.expr
+}
+
+func (xp *XPath) evaluate(element *XMLElement) (any, error) {
+	return xp.root.eval(xpathContext{node: xpathNode{kind: elementNode, element: element}, position: 1, size: 1})
+}
+
+// Select evaluates the expression from element and returns the selected elements in document order.
+// Attribute and text nodes are left out of the result.
+func (xp *XPath) Select(element *XMLElement) ([]*XMLElement, error) {
+	value, err := xp.evaluate(element)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, ok := value.([]xpathNode)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s does not select nodes", ErrXPathType, xp.expr)
+	}
+
+	result := []*XMLElement{}
+
+	for _, node := range nodes {
+		if node.kind == elementNode {
+			result = append(result, node.element)
+		}
+	}
+
+	return result, nil
+}
+
+// SelectOne evaluates the expression from element and returns the first selected element, or nil.
+func (xp *XPath) SelectOne(element *XMLElement) (*XMLElement, error) {
+	elements, err := xp.Select(element)
+	if err != nil || len(elements) == 0 {
+		return nil, err
+	}
+
+	return elements[0], nil
+}
+
+// EvaluateString evaluates the expression from element and converts the result with the string() function.
+func (xp *XPath) EvaluateString(element *XMLElement) (string, error) {
+	value, err := xp.evaluate(element)
+	if err != nil {
+		return "", err
+	}
+
+	return xpathString(value), nil
+}
+
+// EvaluateNumber evaluates the expression from element and converts the result with the number() function.
+func (xp *XPath) EvaluateNumber(element *XMLElement) (float64, error) {
+	value, err := xp.evaluate(element)
+	if err != nil {
+		return math.NaN(), err
+	}
+
+	return xpathNumber(value), nil
+}
+
+// EvaluateBoolean evaluates the expression from element and converts the result with the boolean() function.
+func (xp *XPath) EvaluateBoolean(element *XMLElement) (bool, error) {
+	value, err := xp.evaluate(element)
+	if err != nil {
+		return false, err
+	}
+
+	return xpathBoolean(value), nil
+}
+
+// Select evaluates an XPath expression from the element and returns the selected elements.
+func (n *XMLElement) Select(expr string) ([]*XMLElement, error) {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return xpath.Select(n)
+}
+
+// SelectOne evaluates an XPath expression from the element and returns the first selected element, or nil.
+func (n *XMLElement) SelectOne(expr string) (*XMLElement, error) {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return xpath.SelectOne(n)
+}
+
+// EvaluateString evaluates an XPath expression from the element as a string.
+func (n *XMLElement) EvaluateString(expr string) (string, error) {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		return "", err
+	}
+
+	return xpath.EvaluateString(n)
+}
+
+// EvaluateNumber evaluates an XPath expression from the element as a number.
+func (n *XMLElement) EvaluateNumber(expr string) (float64, error) {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		return math.NaN(), err
+	}
+
+	return xpath.EvaluateNumber(n)
+}
+
+// EvaluateBoolean evaluates an XPath expression from the element as a boolean.
+func (n *XMLElement) EvaluateBoolean(expr string) (bool, error) {
+	xpath, err := CompileXPath(expr)
+	if err != nil {
+		return false, err
+	}
+
+	return xpath.EvaluateBoolean(n)
+}
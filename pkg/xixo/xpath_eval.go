@@ -0,0 +1,494 @@
+package xixo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func xpathString(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case bool:
+		if typed {
+			return "true"
+		}
+
+		return "false"
+	case float64:
+		return formatXPathNumber(typed)
+	case []xpathNode:
+		if len(typed) == 0 {
+			return ""
+		}
+
+		return typed[0].stringValue()
+	default:
+		return ""
+	}
+}
+
+func formatXPathNumber(number float64) string {
+	switch {
+	case math.IsNaN(number):
+		return "NaN"
+	case math.IsInf(number, 1):
+		return "Infinity"
+	case math.IsInf(number, -1):
+		return "-Infinity"
+	case number == 0:
+		return "0"
+	default:
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+}
+
+func parseXPathNumber(value string) float64 {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return math.NaN()
+	}
+
+	return number
+}
+
+func xpathNumber(value any) float64 {
+	switch typed := value.(type) {
+	case float64:
+		return typed
+	case bool:
+		if typed {
+			return 1
+		}
+
+		return 0
+	default:
+		return parseXPathNumber(xpathString(value))
+	}
+}
+
+func xpathBoolean(value any) bool {
+	switch typed := value.(type) {
+	case bool:
+		return typed
+	case float64:
+		return typed != 0 && !math.IsNaN(typed)
+	case string:
+		return typed != ""
+	case []xpathNode:
+		return len(typed) > 0
+	default:
+		return false
+	}
+}
+
+type xpathContext struct {
+	node     xpathNode
+	position int
+	size     int
+}
+
+type xpathExpr interface {
+	eval(ctx xpathContext) (any, error)
+}
+
+type xpathLiteral struct {
+	value any
+}
+
+func (e xpathLiteral) eval(xpathContext) (any, error) {
+	return e.value, nil
+}
+
+type xpathNegate struct {
+	operand xpathExpr
+}
+
+func (e *xpathNegate) eval(ctx xpathContext) (any, error) {
+	value, err := e.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return -xpathNumber(value), nil
+}
+
+type xpathUnion struct {
+	left, right xpathExpr
+}
+
+func (e *xpathUnion) eval(ctx xpathContext) (any, error) {
+	left, err := evalNodes(e.left, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := evalNodes(e.right, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return documentOrder(append(left, right...)), nil
+}
+
+func evalNodes(expr xpathExpr, ctx xpathContext) ([]xpathNode, error) {
+	value, err := expr.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, ok := value.([]xpathNode)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a node-set, got %v", ErrXPathType, value)
+	}
+
+	return nodes, nil
+}
+
+type xpathFilter struct {
+	primary    xpathExpr
+	predicates []xpathExpr
+}
+
+func (e *xpathFilter) eval(ctx xpathContext) (any, error) {
+	nodes, err := evalNodes(e.primary, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, predicate := range e.predicates {
+		nodes, err = filterNodes(nodes, predicate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nodes, nil
+}
+
+// filterNodes keeps the nodes satisfying the predicate, numbers being compared to the proximity position.
+func filterNodes(nodes []xpathNode, predicate xpathExpr) ([]xpathNode, error) {
+	result := []xpathNode{}
+
+	for i, node := range nodes {
+		value, err := predicate.eval(xpathContext{node: node, position: i + 1, size: len(nodes)})
+		if err != nil {
+			return nil, err
+		}
+
+		keep := false
+
+		if number, ok := value.(float64); ok {
+			keep = number == float64(i+1)
+		} else {
+			keep = xpathBoolean(value)
+		}
+
+		if keep {
+			result = append(result, node)
+		}
+	}
+
+	return result, nil
+}
+
+type xpathNodeTest struct {
+	name     string
+	nodeType string
+}
+
+func (t xpathNodeTest) matches(node xpathNode, principal xpathNodeKind) bool {
+	switch t.nodeType {
+	case "node":
+		return true
+	case "text":
+		return node.kind == textNode
+	}
+
+	if node.kind != principal {
+		return false
+	}
+
+	name := node.name()
+
+	switch {
+	case t.name == "*":
+		return true
+	case strings.HasPrefix(t.name, "{"):
+		return t.name == "{"+node.namespaceURI()+"}"+node.localName()
+	case strings.HasSuffix(t.name, ":*"):
+		prefix, _ := splitName(name)
+
+		return prefix+":*" == t.name
+	default:
+		return t.name == name
+	}
+}
+
+type xpathStep struct {
+	axis       string
+	test       xpathNodeTest
+	predicates []xpathExpr
+}
+
+// reverseAxis reports whether the proximity positions of the axis count backwards from the context node.
+func (s *xpathStep) reverseAxis() bool {
+	return s.axis == "parent" || s.axis == "ancestor" || s.axis == "ancestor-or-self" ||
+		s.axis == "preceding-sibling" || s.axis == "preceding"
+}
+
+func (s *xpathStep) axisNodes(node xpathNode) []xpathNode {
+	switch s.axis {
+	case "self":
+		return []xpathNode{node}
+	case "child":
+		return node.children()
+	case "attribute":
+		return node.attributes()
+	case "descendant":
+		return node.descendants(nil)
+	case "descendant-or-self":
+		return node.descendants([]xpathNode{node})
+	case "parent":
+		if parent, ok := node.parentNode(); ok {
+			return []xpathNode{parent}
+		}
+
+		return nil
+	case "ancestor", "ancestor-or-self":
+		result := []xpathNode{}
+		if s.axis == "ancestor-or-self" {
+			result = append(result, node)
+		}
+
+		for parent, ok := node.parentNode(); ok; parent, ok = parent.parentNode() {
+			result = append(result, parent)
+		}
+
+		return result
+	case "following":
+		return node.following()
+	case "preceding":
+		return node.preceding()
+	case "following-sibling", "preceding-sibling":
+		if node.kind != elementNode && node.kind != textNode {
+			return nil
+		}
+
+		parent, ok := node.parentNode()
+		if !ok {
+			return nil
+		}
+
+		siblings := parent.children()
+		for i, sibling := range siblings {
+			if sibling == node {
+				if s.axis == "following-sibling" {
+					return siblings[i+1:]
+				}
+
+				result := make([]xpathNode, 0, i)
+				for j := i - 1; j >= 0; j-- {
+					result = append(result, siblings[j])
+				}
+
+				return result
+			}
+		}
+
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (s *xpathStep) eval(node xpathNode) ([]xpathNode, error) {
+	principal := elementNode
+	if s.axis == "attribute" {
+		principal = attributeNode
+	}
+
+	candidates := s.axisNodes(node)
+	selected := make([]xpathNode, 0, len(candidates))
+
+	for _, candidate := range candidates {
+		if s.test.matches(candidate, principal) {
+			selected = append(selected, candidate)
+		}
+	}
+
+	var err error
+
+	for _, predicate := range s.predicates {
+		selected, err = filterNodes(selected, predicate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return selected, nil
+}
+
+type xpathPath struct {
+	absolute bool
+	start    xpathExpr
+	steps    []*xpathStep
+}
+
+func (e *xpathPath) eval(ctx xpathContext) (any, error) {
+	var (
+		nodes []xpathNode
+		err   error
+	)
+
+	switch {
+	case e.start != nil:
+		nodes, err = evalNodes(e.start, ctx)
+		if err != nil {
+			return nil, err
+		}
+	case e.absolute:
+		nodes = []xpathNode{{kind: documentNode, element: topElement(ctx.node.element)}}
+	default:
+		nodes = []xpathNode{ctx.node}
+	}
+
+	for _, step := range e.steps {
+		next := []xpathNode{}
+
+		for _, node := range nodes {
+			selected, err := step.eval(node)
+			if err != nil {
+				return nil, err
+			}
+
+			next = append(next, selected...)
+		}
+
+		if len(nodes) > 1 || step.reverseAxis() {
+			next = documentOrder(next)
+		}
+
+		nodes = next
+	}
+
+	return nodes, nil
+}
+
+type xpathBinary struct {
+	operator    string
+	left, right xpathExpr
+}
+
+func (e *xpathBinary) eval(ctx xpathContext) (any, error) {
+	left, err := e.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.operator {
+	case "or":
+		if xpathBoolean(left) {
+			return true, nil
+		}
+	case "and":
+		if !xpathBoolean(left) {
+			return false, nil
+		}
+	}
+
+	right, err := e.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.operator {
+	case "or", "and":
+		return xpathBoolean(right), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compareValues(e.operator, left, right), nil
+	case "+":
+		return xpathNumber(left) + xpathNumber(right), nil
+	case "-":
+		return xpathNumber(left) - xpathNumber(right), nil
+	case "*":
+		return xpathNumber(left) * xpathNumber(right), nil
+	case "div":
+		return xpathNumber(left) / xpathNumber(right), nil
+	case "mod":
+		return math.Mod(xpathNumber(left), xpathNumber(right)), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %s", ErrXPathSyntax, e.operator)
+	}
+}
+
+// compareValues implements the XPath 1.0 comparison rules, node-sets comparing true
+// as soon as one of their nodes satisfies the comparison.
+func compareValues(operator string, left, right any) bool {
+	if nodes, ok := left.([]xpathNode); ok {
+		if _, isBool := right.(bool); isBool {
+			return compareAtoms(operator, xpathBoolean(left), right)
+		}
+
+		for _, node := range nodes {
+			if compareValues(operator, node.stringValue(), right) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if nodes, ok := right.([]xpathNode); ok {
+		if _, isBool := left.(bool); isBool {
+			return compareAtoms(operator, left, xpathBoolean(right))
+		}
+
+		for _, node := range nodes {
+			if compareValues(operator, left, node.stringValue()) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return compareAtoms(operator, left, right)
+}
+
+func compareAtoms(operator string, left, right any) bool {
+	if operator == "=" || operator == "!=" {
+		var equal bool
+
+		_, leftBool := left.(bool)
+		_, rightBool := right.(bool)
+		_, leftNumber := left.(float64)
+		_, rightNumber := right.(float64)
+
+		switch {
+		case leftBool || rightBool:
+			equal = xpathBoolean(left) == xpathBoolean(right)
+		case leftNumber || rightNumber:
+			equal = xpathNumber(left) == xpathNumber(right)
+		default:
+			equal = xpathString(left) == xpathString(right)
+		}
+
+		return equal == (operator == "=")
+	}
+
+	a, b := xpathNumber(left), xpathNumber(right)
+
+	switch operator {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
@@ -0,0 +1,226 @@
+package xixo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type xpathFunc struct {
+	minArgs int
+	maxArgs int // -1 for variadic functions
+	call    func(ctx xpathContext, args []any) (any, error)
+}
+
+type xpathCall struct {
+	name     string
+	function xpathFunc
+	args     []xpathExpr
+}
+
+func (e *xpathCall) eval(ctx xpathContext) (any, error) {
+	args := make([]any, 0, len(e.args))
+
+	for _, arg := range e.args {
+		value, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, value)
+	}
+
+	return e.function.call(ctx, args)
+}
+
+// stringArg returns the string value of the optional argument, defaulting to the context node.
+func stringArg(ctx xpathContext, args []any) string {
+	if len(args) == 0 {
+		return ctx.node.stringValue()
+	}
+
+	return xpathString(args[0])
+}
+
+// nodeArg returns the first node of the optional node-set argument, defaulting to the context node.
+func nodeArg(ctx xpathContext, args []any) (xpathNode, bool, error) {
+	if len(args) == 0 {
+		return ctx.node, true, nil
+	}
+
+	nodes, ok := args[0].([]xpathNode)
+	if !ok {
+		return xpathNode{}, false, fmt.Errorf("%w: expected a node-set, got %v", ErrXPathType, args[0])
+	}
+
+	if len(nodes) == 0 {
+		return xpathNode{}, false, nil
+	}
+
+	return nodes[0], true, nil
+}
+
+func nameFunction(name func(xpathNode) string) func(xpathContext, []any) (any, error) {
+	return func(ctx xpathContext, args []any) (any, error) {
+		node, ok, err := nodeArg(ctx, args)
+		if err != nil || !ok {
+			return "", err
+		}
+
+		return name(node), nil
+	}
+}
+
+//nolint:gochecknoglobals
+var xpathFunctions = map[string]xpathFunc{
+	"last": {0, 0, func(ctx xpathContext, _ []any) (any, error) {
+		return float64(ctx.size), nil
+	}},
+	"position": {0, 0, func(ctx xpathContext, _ []any) (any, error) {
+		return float64(ctx.position), nil
+	}},
+	"count": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		nodes, ok := args[0].([]xpathNode)
+		if !ok {
+			return nil, fmt.Errorf("%w: count() expects a node-set", ErrXPathType)
+		}
+
+		return float64(len(nodes)), nil
+	}},
+	"name":          {0, 1, nameFunction(xpathNode.name)},
+	"local-name":    {0, 1, nameFunction(xpathNode.localName)},
+	"namespace-uri": {0, 1, nameFunction(xpathNode.namespaceURI)},
+	"string": {0, 1, func(ctx xpathContext, args []any) (any, error) {
+		return stringArg(ctx, args), nil
+	}},
+	"concat": {2, -1, func(_ xpathContext, args []any) (any, error) {
+		var builder strings.Builder
+		for _, arg := range args {
+			builder.WriteString(xpathString(arg))
+		}
+
+		return builder.String(), nil
+	}},
+	"starts-with": {2, 2, func(_ xpathContext, args []any) (any, error) {
+		return strings.HasPrefix(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"ends-with": {2, 2, func(_ xpathContext, args []any) (any, error) {
+		return strings.HasSuffix(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"contains": {2, 2, func(_ xpathContext, args []any) (any, error) {
+		return strings.Contains(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"substring-before": {2, 2, func(_ xpathContext, args []any) (any, error) {
+		before, _, found := strings.Cut(xpathString(args[0]), xpathString(args[1]))
+		if !found {
+			return "", nil
+		}
+
+		return before, nil
+	}},
+	"substring-after": {2, 2, func(_ xpathContext, args []any) (any, error) {
+		_, after, _ := strings.Cut(xpathString(args[0]), xpathString(args[1]))
+
+		return after, nil
+	}},
+	"substring": {2, 3, func(_ xpathContext, args []any) (any, error) {
+		return substring(xpathString(args[0]), args[1:]), nil
+	}},
+	"string-length": {0, 1, func(ctx xpathContext, args []any) (any, error) {
+		return float64(len([]rune(stringArg(ctx, args)))), nil
+	}},
+	"normalize-space": {0, 1, func(ctx xpathContext, args []any) (any, error) {
+		return strings.Join(strings.Fields(stringArg(ctx, args)), " "), nil
+	}},
+	"translate": {3, 3, func(_ xpathContext, args []any) (any, error) {
+		return translate(xpathString(args[0]), xpathString(args[1]), xpathString(args[2])), nil
+	}},
+	"upper-case": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return strings.ToUpper(xpathString(args[0])), nil
+	}},
+	"lower-case": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return strings.ToLower(xpathString(args[0])), nil
+	}},
+	"boolean": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return xpathBoolean(args[0]), nil
+	}},
+	"not": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return !xpathBoolean(args[0]), nil
+	}},
+	"true": {0, 0, func(xpathContext, []any) (any, error) {
+		return true, nil
+	}},
+	"false": {0, 0, func(xpathContext, []any) (any, error) {
+		return false, nil
+	}},
+	"number": {0, 1, func(ctx xpathContext, args []any) (any, error) {
+		if len(args) == 0 {
+			return parseXPathNumber(ctx.node.stringValue()), nil
+		}
+
+		return xpathNumber(args[0]), nil
+	}},
+	"sum": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		nodes, ok := args[0].([]xpathNode)
+		if !ok {
+			return nil, fmt.Errorf("%w: sum() expects a node-set", ErrXPathType)
+		}
+
+		sum := 0.0
+		for _, node := range nodes {
+			sum += parseXPathNumber(node.stringValue())
+		}
+
+		return sum, nil
+	}},
+	"floor": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return math.Floor(xpathNumber(args[0])), nil
+	}},
+	"ceiling": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return math.Ceil(xpathNumber(args[0])), nil
+	}},
+	"round": {1, 1, func(_ xpathContext, args []any) (any, error) {
+		return math.Floor(xpathNumber(args[0]) + 0.5), nil
+	}},
+}
+
+// substring implements substring() with its 1-based, rounded positions.
+func substring(value string, args []any) string {
+	runes := []rune(value)
+	start := math.Floor(xpathNumber(args[0]) + 0.5)
+	end := math.Inf(1)
+
+	if len(args) > 1 {
+		end = start + math.Floor(xpathNumber(args[1])+0.5)
+	}
+
+	var builder strings.Builder
+
+	for i, r := range runes {
+		position := float64(i + 1)
+		if position >= start && position < end {
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+// translate implements translate(), characters of from without counterpart in to being removed.
+func translate(value, from, to string) string {
+	fromRunes, toRunes := []rune(from), []rune(to)
+
+	return strings.Map(func(r rune) rune {
+		for i, f := range fromRunes {
+			if f == r {
+				if i < len(toRunes) {
+					return toRunes[i]
+				}
+
+				return -1
+			}
+		}
+
+		return r
+	}, value)
+}
@@ -0,0 +1,231 @@
+package xixo
+
+import (
+	"fmt"
+	"strings"
+)
+
+type xpathTokenKind int
+
+const (
+	xpathEOF xpathTokenKind = iota
+	xpathName
+	xpathAxis
+	xpathFunction
+	xpathNodeType
+	xpathNumberLiteral
+	xpathStringLiteral
+	xpathOperator
+	xpathSlash
+	xpathDoubleSlash
+	xpathDot
+	xpathDotDot
+	xpathAt
+	xpathLBracket
+	xpathRBracket
+	xpathLParen
+	xpathRParen
+	xpathComma
+)
+
+type xpathToken struct {
+	kind  xpathTokenKind
+	value string
+}
+
+type xpathLexer struct {
+	input  string
+	pos    int
+	tokens []xpathToken
+}
+
+func (l *xpathLexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w %q at offset %d: %s", ErrXPathSyntax, l.input, l.pos, fmt.Sprintf(format, args...))
+}
+
+func (l *xpathLexer) emit(kind xpathTokenKind, value string) {
+	l.tokens = append(l.tokens, xpathToken{kind: kind, value: value})
+}
+
+// operatorContext reports whether the next token must be read as an operator (* as multiply, and, or,
+// div, mod), which is the case when a preceding token exists that is not @, ::, (, [, , or an operator.
+func (l *xpathLexer) operatorContext() bool {
+	if len(l.tokens) == 0 {
+		return false
+	}
+
+	switch l.tokens[len(l.tokens)-1].kind {
+	case xpathAt, xpathAxis, xpathLParen, xpathLBracket, xpathComma, xpathOperator,
+		xpathSlash, xpathDoubleSlash, xpathFunction, xpathNodeType:
+		return false
+	default:
+		return true
+	}
+}
+
+//nolint:gocyclo
+func (l *xpathLexer) tokenize() error {
+	for {
+		for l.pos < len(l.input) && isXPathSpace(l.input[l.pos]) {
+			l.pos++
+		}
+
+		if l.pos >= len(l.input) {
+			l.emit(xpathEOF, "")
+
+			return nil
+		}
+
+		c := l.input[l.pos]
+		rest := l.input[l.pos:]
+
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			l.emit(xpathDoubleSlash, "//")
+			l.pos += 2
+		case c == '/':
+			l.emit(xpathSlash, "/")
+			l.pos++
+		case strings.HasPrefix(rest, ".."):
+			l.emit(xpathDotDot, "..")
+			l.pos += 2
+		case c == '.' && (len(rest) == 1 || !isDigit(rest[1])):
+			l.emit(xpathDot, ".")
+			l.pos++
+		case c == '@':
+			l.emit(xpathAt, "@")
+			l.pos++
+		case c == '[':
+			l.emit(xpathLBracket, "[")
+			l.pos++
+		case c == ']':
+			l.emit(xpathRBracket, "]")
+			l.pos++
+		case c == '(':
+			l.emit(xpathLParen, "(")
+			l.pos++
+		case c == ')':
+			l.emit(xpathRParen, ")")
+			l.pos++
+		case c == ',':
+			l.emit(xpathComma, ",")
+			l.pos++
+		case strings.HasPrefix(rest, "!="), strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="):
+			l.emit(xpathOperator, rest[:2])
+			l.pos += 2
+		case c == '=' || c == '<' || c == '>' || c == '+' || c == '-' || c == '|':
+			l.emit(xpathOperator, rest[:1])
+			l.pos++
+		case c == '*' && l.operatorContext():
+			l.emit(xpathOperator, "*")
+			l.pos++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(rest[1:], c)
+			if end < 0 {
+				return l.errorf("unterminated string literal")
+			}
+
+			l.emit(xpathStringLiteral, rest[1:end+1])
+			l.pos += end + 2
+		case isDigit(c) || c == '.':
+			end := 0
+			for end < len(rest) && (isDigit(rest[end]) || rest[end] == '.') {
+				end++
+			}
+
+			l.emit(xpathNumberLiteral, rest[:end])
+			l.pos += end
+		case c == '*' || c == '{' || isNameStart(c):
+			if err := l.name(); err != nil {
+				return err
+			}
+		default:
+			return l.errorf("unexpected character %q", c)
+		}
+	}
+}
+
+// name reads a name test, an operator name, an axis name, a function name or a node type.
+func (l *xpathLexer) name() error {
+	start := l.pos
+
+	if l.input[l.pos] == '{' {
+		end := strings.IndexByte(l.input[l.pos:], '}')
+		if end < 0 {
+			return l.errorf("unterminated namespace uri")
+		}
+
+		l.pos += end + 1
+	}
+
+	l.pos = scanName(l.input, l.pos)
+
+	// prefix:local or prefix:*
+	if l.pos < len(l.input)-1 && l.input[l.pos] == ':' && l.input[l.pos+1] != ':' {
+		l.pos = scanName(l.input, l.pos+1)
+	}
+
+	name := l.input[start:l.pos]
+
+	if name == "" {
+		return l.errorf("expected a name")
+	}
+
+	if l.operatorContext() {
+		switch name {
+		case "and", "or", "div", "mod":
+			l.emit(xpathOperator, name)
+
+			return nil
+		}
+	}
+
+	next := l.pos
+	for next < len(l.input) && isXPathSpace(l.input[next]) {
+		next++
+	}
+
+	switch {
+	case strings.HasPrefix(l.input[next:], "::"):
+		l.emit(xpathAxis, name)
+		l.pos = next + 2
+	case strings.HasPrefix(l.input[next:], "("):
+		if name == "node" || name == "text" || name == "comment" || name == "processing-instruction" {
+			l.emit(xpathNodeType, name)
+		} else {
+			l.emit(xpathFunction, name)
+		}
+	default:
+		l.emit(xpathName, name)
+	}
+
+	return nil
+}
+
+func scanName(input string, pos int) int {
+	if pos < len(input) && input[pos] == '*' {
+		return pos + 1
+	}
+
+	for pos < len(input) && isNameChar(input[pos]) {
+		pos++
+	}
+
+	return pos
+}
+
+func isXPathSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c) || c == '-' || c == '.'
+}
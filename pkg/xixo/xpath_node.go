@@ -0,0 +1,310 @@
+package xixo
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+type xpathNodeKind int
+
+const (
+	documentNode xpathNodeKind = iota
+	elementNode
+	attributeNode
+	textNode
+)
+
+// xpathNode is a node of the data model built over an XMLElement tree.
+// The document node wraps the topmost element, attribute and text nodes their owner element.
+type xpathNode struct {
+	kind    xpathNodeKind
+	element *XMLElement
+	attr    string
+}
+
+func (node xpathNode) name() string {
+	switch node.kind {
+	case elementNode:
+		return node.element.Name
+	case attributeNode:
+		return node.attr
+	default:
+		return ""
+	}
+}
+
+func (node xpathNode) localName() string {
+	_, local := splitName(node.name())
+
+	return local
+}
+
+func (node xpathNode) namespaceURI() string {
+	switch node.kind {
+	case elementNode:
+		return node.element.namespaceURI
+	case attributeNode:
+		return node.element.AttributeNamespaceURI(node.attr)
+	default:
+		return ""
+	}
+}
+
+func (node xpathNode) stringValue() string {
+	switch node.kind {
+	case attributeNode:
+		return node.element.Attrs[node.attr].Value
+	case textNode:
+		return directText(node.element)
+	default:
+		return textContent(node.element)
+	}
+}
+
+// directText returns the text held by the element itself, without the text of its descendants.
+func directText(element *XMLElement) string {
+	nodes, text := element.content()
+
+	return nodesText(nodes) + text
+}
+
+// textContent returns the concatenation of the texts of the element and its descendants in document order.
+func textContent(element *XMLElement) string {
+	var builder strings.Builder
+
+	var walk func(*XMLElement)
+
+	walk = func(current *XMLElement) {
+		nodes, text := current.content()
+
+		for _, node := range nodes {
+			switch node.Kind {
+			case ElementNode:
+				if node.Element != nil {
+					walk(node.Element)
+				}
+			case TextNode, CDATANode:
+				builder.WriteString(node.Data)
+			case CommentNode:
+			}
+		}
+
+		builder.WriteString(text)
+	}
+
+	walk(element)
+
+	return builder.String()
+}
+
+func topElement(element *XMLElement) *XMLElement {
+	for element.parent != nil {
+		element = element.parent
+	}
+
+	return element
+}
+
+func (node xpathNode) parentNode() (xpathNode, bool) {
+	switch node.kind {
+	case documentNode:
+		return xpathNode{}, false
+	case elementNode:
+		if node.element.parent == nil {
+			return xpathNode{kind: documentNode, element: node.element}, true
+		}
+
+		return xpathNode{kind: elementNode, element: node.element.parent}, true
+	default:
+		return xpathNode{kind: elementNode, element: node.element}, true
+	}
+}
+
+func (node xpathNode) children() []xpathNode {
+	switch node.kind {
+	case documentNode:
+		return []xpathNode{{kind: elementNode, element: node.element}}
+	case elementNode:
+		elements := node.element.Children()
+		children := make([]xpathNode, 0, len(elements)+1)
+
+		for _, child := range elements {
+			children = append(children, xpathNode{kind: elementNode, element: child})
+		}
+
+		if directText(node.element) != "" {
+			children = append(children, xpathNode{kind: textNode, element: node.element})
+		}
+
+		return children
+	default:
+		return nil
+	}
+}
+
+func (node xpathNode) attributes() []xpathNode {
+	if node.kind != elementNode {
+		return nil
+	}
+
+	attributes := make([]xpathNode, 0, len(node.element.AttrKeys))
+
+	for _, key := range node.element.AttrKeys {
+		if prefix, local := splitName(key); prefix == "xmlns" || (prefix == "" && local == "xmlns") {
+			continue
+		}
+
+		attributes = append(attributes, xpathNode{kind: attributeNode, element: node.element, attr: key})
+	}
+
+	return attributes
+}
+
+func (node xpathNode) descendants(result []xpathNode) []xpathNode {
+	for _, child := range node.children() {
+		result = append(result, child)
+		result = child.descendants(result)
+	}
+
+	return result
+}
+
+// following returns the nodes after node in document order, its descendants excepted.
+func (node xpathNode) following() []xpathNode {
+	result := []xpathNode{}
+
+	current := node
+	if current.kind == attributeNode {
+		// the content of the element follows its attributes
+		current, _ = current.parentNode()
+		result = current.descendants(result)
+	}
+
+	for {
+		parent, ok := current.parentNode()
+		if !ok {
+			return result
+		}
+
+		siblings := parent.children()
+		for _, sibling := range siblings[slices.Index(siblings, current)+1:] {
+			result = append(result, sibling)
+			result = sibling.descendants(result)
+		}
+
+		current = parent
+	}
+}
+
+// preceding returns the nodes before node in reverse document order, its ancestors excepted.
+func (node xpathNode) preceding() []xpathNode {
+	result := []xpathNode{}
+
+	current := node
+	if current.kind == attributeNode {
+		current, _ = current.parentNode()
+	}
+
+	for {
+		parent, ok := current.parentNode()
+		if !ok {
+			return result
+		}
+
+		siblings := parent.children()
+		for i := slices.Index(siblings, current) - 1; i >= 0; i-- {
+			descendants := siblings[i].descendants(nil)
+			for j := len(descendants) - 1; j >= 0; j-- {
+				result = append(result, descendants[j])
+			}
+
+			result = append(result, siblings[i])
+		}
+
+		current = parent
+	}
+}
+
+// orderKey locates the node in document order: the indexes of its ancestors from the document down,
+// attributes sorting right after their element and text after the children of its element.
+func (node xpathNode) orderKey() []int {
+	if node.kind == documentNode {
+		return []int{}
+	}
+
+	key := []int{}
+
+	for current := node.element; current != nil; current = current.parent {
+		index := 0
+
+		if current.parent != nil {
+			for i, sibling := range current.parent.Children() {
+				if sibling == current {
+					index = i
+
+					break
+				}
+			}
+		}
+
+		key = append(key, index)
+	}
+
+	// reverse to read from the top element down
+	for i, j := 0, len(key)-1; i < j; i, j = i+1, j-1 {
+		key[i], key[j] = key[j], key[i]
+	}
+
+	switch node.kind {
+	case attributeNode:
+		for i, attr := range node.element.AttrKeys {
+			if attr == node.attr {
+				return append(key, -2, i)
+			}
+		}
+	case textNode:
+		return append(key, len(node.element.Children()))
+	}
+
+	return key
+}
+
+func compareKeys(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// documentOrder sorts nodes in document order and removes duplicates.
+func documentOrder(nodes []xpathNode) []xpathNode {
+	seen := make(map[xpathNode]bool, len(nodes))
+	unique := make([]xpathNode, 0, len(nodes))
+
+	for _, node := range nodes {
+		if !seen[node] {
+			seen[node] = true
+
+			unique = append(unique, node)
+		}
+	}
+
+	keys := make(map[xpathNode][]int, len(unique))
+	for _, node := range unique {
+		keys[node] = node.orderKey()
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		return compareKeys(keys[unique[i]], keys[unique[j]]) < 0
+	})
+
+	return unique
+}
@@ -0,0 +1,370 @@
+package xixo
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type xpathParser struct {
+	lexer xpathLexer
+	index int
+}
+
+func (p *xpathParser) peek() xpathToken {
+	return p.lexer.tokens[p.index]
+}
+
+func (p *xpathParser) next() xpathToken {
+	token := p.lexer.tokens[p.index]
+	if token.kind != xpathEOF {
+		p.index++
+	}
+
+	return token
+}
+
+func (p *xpathParser) at(kind xpathTokenKind) bool {
+	return p.peek().kind == kind
+}
+
+func (p *xpathParser) atOperator(operators ...string) bool {
+	token := p.peek()
+	if token.kind != xpathOperator {
+		return false
+	}
+
+	for _, operator := range operators {
+		if token.value == operator {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *xpathParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w %q: %s", ErrXPathSyntax, p.lexer.input, fmt.Sprintf(format, args...))
+}
+
+func (p *xpathParser) expect(kind xpathTokenKind, what string) error {
+	if !p.at(kind) {
+		return p.errorf("expected %s", what)
+	}
+
+	p.next()
+
+	return nil
+}
+
+func (p *xpathParser) parseExpr() (xpathExpr, error) {
+	return p.parseBinary(0)
+}
+
+// xpathPrecedence lists the binary operators from the loosest to the tightest binding.
+var xpathPrecedence = [][]string{
+	{"or"},
+	{"and"},
+	{"=", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "div", "mod"},
+}
+
+func (p *xpathParser) parseBinary(level int) (xpathExpr, error) {
+	if level == len(xpathPrecedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.atOperator(xpathPrecedence[level]...) {
+		operator := p.next().value
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &xpathBinary{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *xpathParser) parseUnary() (xpathExpr, error) {
+	if p.atOperator("-") {
+		p.next()
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &xpathNegate{operand: operand}, nil
+	}
+
+	return p.parseUnion()
+}
+
+func (p *xpathParser) parseUnion() (xpathExpr, error) {
+	left, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.atOperator("|") {
+		p.next()
+
+		right, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &xpathUnion{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *xpathParser) parsePath() (xpathExpr, error) {
+	switch p.peek().kind {
+	case xpathLParen, xpathStringLiteral, xpathNumberLiteral, xpathFunction:
+		filter, err := p.parseFilter()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.at(xpathSlash) && !p.at(xpathDoubleSlash) {
+			return filter, nil
+		}
+
+		path := &xpathPath{start: filter}
+
+		return path, p.parseRelativePath(path)
+	case xpathSlash:
+		p.next()
+
+		path := &xpathPath{absolute: true}
+
+		if !p.startsStep() {
+			return path, nil
+		}
+
+		return path, p.parseRelativePath(path)
+	case xpathDoubleSlash:
+		path := &xpathPath{absolute: true}
+
+		return path, p.parseRelativePath(path)
+	default:
+		path := &xpathPath{}
+
+		return path, p.parseRelativePath(path)
+	}
+}
+
+func (p *xpathParser) startsStep() bool {
+	switch p.peek().kind {
+	case xpathName, xpathAxis, xpathNodeType, xpathDot, xpathDotDot, xpathAt:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *xpathParser) parseRelativePath(path *xpathPath) error {
+	if p.at(xpathDoubleSlash) {
+		p.next()
+		path.steps = append(path.steps, descendantOrSelfStep())
+	} else if p.at(xpathSlash) && path.start != nil {
+		p.next()
+	}
+
+	for {
+		step, err := p.parseStep()
+		if err != nil {
+			return err
+		}
+
+		path.steps = append(path.steps, step)
+
+		switch {
+		case p.at(xpathSlash):
+			p.next()
+		case p.at(xpathDoubleSlash):
+			p.next()
+			path.steps = append(path.steps, descendantOrSelfStep())
+		default:
+			return nil
+		}
+	}
+}
+
+func descendantOrSelfStep() *xpathStep {
+	return &xpathStep{axis: "descendant-or-self", test: xpathNodeTest{nodeType: "node"}}
+}
+
+var xpathAxes = map[string]bool{
+	"child": true, "descendant": true, "descendant-or-self": true, "self": true, "parent": true,
+	"ancestor": true, "ancestor-or-self": true, "following": true, "following-sibling": true, "preceding": true,
+	"preceding-sibling": true, "attribute": true,
+}
+
+func (p *xpathParser) parseStep() (*xpathStep, error) {
+	step := &xpathStep{axis: "child"}
+
+	switch p.peek().kind {
+	case xpathDot:
+		p.next()
+
+		return &xpathStep{axis: "self", test: xpathNodeTest{nodeType: "node"}}, nil
+	case xpathDotDot:
+		p.next()
+
+		return &xpathStep{axis: "parent", test: xpathNodeTest{nodeType: "node"}}, nil
+	case xpathAt:
+		p.next()
+
+		step.axis = "attribute"
+	case xpathAxis:
+		axis := p.next().value
+		if !xpathAxes[axis] {
+			return nil, p.errorf("unsupported axis %s", axis)
+		}
+
+		step.axis = axis
+	}
+
+	switch token := p.next(); token.kind {
+	case xpathName:
+		step.test = xpathNodeTest{name: token.value}
+	case xpathNodeType:
+		if token.value != "node" && token.value != "text" {
+			return nil, p.errorf("unsupported node test %s()", token.value)
+		}
+
+		if err := p.expect(xpathLParen, "("); err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(xpathRParen, ")"); err != nil {
+			return nil, err
+		}
+
+		step.test = xpathNodeTest{nodeType: token.value}
+	default:
+		return nil, p.errorf("expected a node test")
+	}
+
+	predicates, err := p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+
+	step.predicates = predicates
+
+	return step, nil
+}
+
+func (p *xpathParser) parsePredicates() ([]xpathExpr, error) {
+	predicates := []xpathExpr{}
+
+	for p.at(xpathLBracket) {
+		p.next()
+
+		predicate, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(xpathRBracket, "]"); err != nil {
+			return nil, err
+		}
+
+		predicates = append(predicates, predicate)
+	}
+
+	return predicates, nil
+}
+
+func (p *xpathParser) parseFilter() (xpathExpr, error) {
+	var (
+		primary xpathExpr
+		err     error
+	)
+
+	switch token := p.next(); token.kind {
+	case xpathLParen:
+		primary, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(xpathRParen, ")"); err != nil {
+			return nil, err
+		}
+	case xpathStringLiteral:
+		primary = xpathLiteral{value: token.value}
+	case xpathNumberLiteral:
+		number, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", token.value)
+		}
+
+		primary = xpathLiteral{value: number}
+	default:
+		primary, err = p.parseFunction(token.value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	predicates, err := p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(predicates) == 0 {
+		return primary, nil
+	}
+
+	return &xpathFilter{primary: primary, predicates: predicates}, nil
+}
+
+func (p *xpathParser) parseFunction(name string) (xpathExpr, error) {
+	function, ok := xpathFunctions[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s()", name)
+	}
+
+	if err := p.expect(xpathLParen, "("); err != nil {
+		return nil, err
+	}
+
+	args := []xpathExpr{}
+
+	for !p.at(xpathRParen) {
+		if len(args) > 0 {
+			if err := p.expect(xpathComma, ","); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	p.next()
+
+	if len(args) < function.minArgs || (function.maxArgs >= 0 && len(args) > function.maxArgs) {
+		return nil, p.errorf("wrong number of arguments for %s()", name)
+	}
+
+	return &xpathCall{name: name, function: function, args: args}, nil
+}
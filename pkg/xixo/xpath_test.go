@@ -0,0 +1,186 @@
+package xixo_test
+
+import (
+	"testing"
+
+	"github.com/CGI-FR/xixo/pkg/xixo"
+	"github.com/stretchr/testify/assert"
+)
+
+const xpathXML = `<root xmlns:p="urn:p">
+	<customer id="1" role="customer">
+		<name>Alice</name>
+		<phone type="home">111</phone>
+		<phone type="work">222</phone>
+		<address><city>Nantes</city><zip>44000</zip></address>
+	</customer>
+	<customer id="2" role="bank">
+		<name>Bob &amp; co</name>
+		<p:phone type="work">333</p:phone>
+		<address><city>Paris</city><zip>75000</zip></address>
+	</customer>
+	<total>12.5</total>
+</root>`
+
+func names(elements []*xixo.XMLElement) []string {
+	result := []string{}
+	for _, element := range elements {
+		result = append(result, element.Name+"="+element.InnerText)
+	}
+
+	return result
+}
+
+func TestXPathSelect(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(xpathXML)
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{expr: "customer/name", expected: []string{"name=Alice", "name=Bob & co"}},
+		{expr: "/root/customer/name", expected: []string{"name=Alice", "name=Bob & co"}},
+		{expr: "//city", expected: []string{"city=Nantes", "city=Paris"}},
+		{expr: ".//zip", expected: []string{"zip=44000", "zip=75000"}},
+		{expr: "customer[2]/name", expected: []string{"name=Bob & co"}},
+		{expr: "customer[last()]/name", expected: []string{"name=Bob & co"}},
+		{expr: "customer[position() = 1]/phone[2]", expected: []string{"phone=222"}},
+		{expr: "customer[@role='customer']/phone", expected: []string{"phone=111", "phone=222"}},
+		{expr: "customer[@id > 1]/name", expected: []string{"name=Bob & co"}},
+		{expr: "customer[name = 'Alice']/address/city", expected: []string{"city=Nantes"}},
+		{expr: "customer[address/zip >= 50000]/name", expected: []string{"name=Bob & co"}},
+		{expr: "//phone[@type='work']", expected: []string{"phone=222"}},
+		{expr: "//p:phone", expected: []string{"p:phone=333"}},
+		{expr: "//{urn:p}phone", expected: []string{"p:phone=333"}},
+		{expr: "//*[local-name() = 'phone']", expected: []string{"phone=111", "phone=222", "p:phone=333"}},
+		{expr: "//city/../zip", expected: []string{"zip=44000", "zip=75000"}},
+		{expr: "//city/parent::address/parent::customer/name", expected: []string{"name=Alice", "name=Bob & co"}},
+		{expr: "//zip/ancestor::customer[1]/@id/../name", expected: []string{"name=Alice", "name=Bob & co"}},
+		{expr: "customer/name/following-sibling::phone[1]", expected: []string{"phone=111"}},
+		{expr: "//address/preceding-sibling::*[1]", expected: []string{"phone=222", "p:phone=333"}},
+		{expr: "customer[1]/address/following::name", expected: []string{"name=Bob & co"}},
+		{expr: "customer[1]/@id/following::name[1]", expected: []string{"name=Alice"}},
+		{expr: "customer[2]/name/preceding::phone[1]", expected: []string{"phone=222"}},
+		{expr: "//zip/preceding::city", expected: []string{"city=Nantes", "city=Paris"}},
+		{expr: "total/preceding::*[1]", expected: []string{"zip=75000"}},
+		{expr: "total | customer[1]/name", expected: []string{"name=Alice", "total=12.5"}},
+		{expr: "customer[starts-with(name, 'Bo')]/address/city", expected: []string{"city=Paris"}},
+		{expr: "customer[contains(name, '&')]/@id", expected: []string{}},
+		{expr: "customer[not(@role = 'bank')]/name", expected: []string{"name=Alice"}},
+		{expr: "customer[count(phone) = 2 and @id = '1']/name", expected: []string{"name=Alice"}},
+		{expr: "customer[@role='nobody']", expected: []string{}},
+	}
+
+	for _, testCase := range tests {
+		selected, err := root.Select(testCase.expr)
+		assert.Nil(t, err, testCase.expr)
+		assert.Equal(t, testCase.expected, names(selected), testCase.expr)
+	}
+}
+
+func TestXPathSelectOne(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(xpathXML)
+
+	city, err := root.SelectOne("customer[2]/address/city")
+	assert.Nil(t, err)
+	assert.Equal(t, "Paris", city.InnerText)
+
+	customer, err := city.SelectOne("ancestor::customer")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", customer.Attrs["id"].Value)
+
+	missing, err := root.SelectOne("customer[3]")
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestXPathEvaluate(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(xpathXML)
+
+	stringCases := []struct {
+		expr     string
+		expected string
+	}{
+		{expr: "customer[2]/@role", expected: "bank"},
+		{expr: "customer/name", expected: "Alice"},
+		{expr: "concat(customer[1]/name, '-', customer[1]/@id)", expected: "Alice-1"},
+		{expr: "substring-before(customer[2]/name, ' ')", expected: "Bob"},
+		{expr: "substring-after(customer[2]/name, '& ')", expected: "co"},
+		{expr: "substring('12345', 2, 3)", expected: "234"},
+		{expr: "normalize-space('  a   b ')", expected: "a b"},
+		{expr: "translate('abc', 'abc', 'AB')", expected: "AB"},
+		{expr: "string(customer[1]/address)", expected: "Nantes44000"},
+		{expr: "name(*[3])", expected: "total"},
+		{expr: "namespace-uri(//p:phone)", expected: "urn:p"},
+		{expr: "string(count(//phone))", expected: "2"},
+		{expr: "upper-case(customer[1]/name)", expected: "ALICE"},
+	}
+
+	for _, testCase := range stringCases {
+		value, err := root.EvaluateString(testCase.expr)
+		assert.Nil(t, err, testCase.expr)
+		assert.Equal(t, testCase.expected, value, testCase.expr)
+	}
+
+	numbers := []struct {
+		expr     string
+		expected float64
+	}{
+		{expr: "total * 2", expected: 25},
+		{expr: "sum(//zip) div 1000", expected: 119},
+		{expr: "floor(total) + ceiling(total) - round(total)", expected: 12},
+		{expr: "string-length(customer[1]/name)", expected: 5},
+		{expr: "7 mod 3", expected: 1},
+		{expr: "-count(customer)", expected: -2},
+	}
+
+	for _, testCase := range numbers {
+		value, err := root.EvaluateNumber(testCase.expr)
+		assert.Nil(t, err, testCase.expr)
+		assert.Equal(t, testCase.expected, value, testCase.expr)
+	}
+
+	booleans := []struct {
+		expr     string
+		expected bool
+	}{
+		{expr: "customer/@role = 'bank'", expected: true},
+		{expr: "customer/@role != 'bank'", expected: true},
+		{expr: "not(customer/@role = 'other')", expected: true},
+		{expr: "total > 12 and total < 13", expected: true},
+		{expr: "customer[3] or false()", expected: false},
+		{expr: "boolean(//zip)", expected: true},
+	}
+
+	for _, testCase := range booleans {
+		value, err := root.EvaluateBoolean(testCase.expr)
+		assert.Nil(t, err, testCase.expr)
+		assert.Equal(t, testCase.expected, value, testCase.expr)
+	}
+}
+
+func TestXPathErrors(t *testing.T) {
+	t.Parallel()
+
+	root := createTreeFromXMLString(xpathXML)
+
+	for _, expr := range []string{"customer[", "unknown()", "'unterminated", "namespace::x", "customer/", "count()"} {
+		_, err := xixo.CompileXPath(expr)
+		assert.ErrorIs(t, err, xixo.ErrXPathSyntax, expr)
+	}
+
+	_, err := xixo.CompileXPath("ancestor::customer/namespace::p")
+	assert.ErrorContains(t, err, "unsupported axis namespace")
+
+	_, err = root.Select("count(customer)")
+	assert.ErrorIs(t, err, xixo.ErrXPathType)
+
+	assert.Panics(t, func() { xixo.MustCompileXPath("customer[") })
+	assert.Equal(t, "customer[1]", xixo.MustCompileXPath("customer[1]").String())
+}